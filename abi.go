@@ -0,0 +1,818 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+
+const AbiMaxDepth int = 32
+
+//binary layout of abi_def (eosio::abi/1.1)
+const AbiDefAbi string = `{
+	"version": "eosio::abi/1.1",
+	"structs": [
+		{ "name": "type_def", "base": "", "fields": [
+			{ "name": "new_type_name", "type": "string" }, { "name": "type", "type": "string" } ] },
+		{ "name": "field_def", "base": "", "fields": [
+			{ "name": "name", "type": "string" }, { "name": "type", "type": "string" } ] },
+		{ "name": "struct_def", "base": "", "fields": [
+			{ "name": "name", "type": "string" }, { "name": "base", "type": "string" },
+			{ "name": "fields", "type": "field_def[]" } ] },
+		{ "name": "action_def", "base": "", "fields": [
+			{ "name": "name", "type": "name" }, { "name": "type", "type": "string" },
+			{ "name": "ricardian_contract", "type": "string" } ] },
+		{ "name": "table_def", "base": "", "fields": [
+			{ "name": "name", "type": "name" }, { "name": "index_type", "type": "string" },
+			{ "name": "key_names", "type": "string[]" }, { "name": "key_types", "type": "string[]" },
+			{ "name": "type", "type": "string" } ] },
+		{ "name": "clause_pair", "base": "", "fields": [
+			{ "name": "id", "type": "string" }, { "name": "body", "type": "string" } ] },
+		{ "name": "error_message", "base": "", "fields": [
+			{ "name": "error_code", "type": "uint64" }, { "name": "error_msg", "type": "string" } ] },
+		{ "name": "extension", "base": "", "fields": [
+			{ "name": "type", "type": "uint16" }, { "name": "data", "type": "bytes" } ] },
+		{ "name": "variant_def", "base": "", "fields": [
+			{ "name": "name", "type": "string" }, { "name": "types", "type": "string[]" } ] },
+		{ "name": "abi_def", "base": "", "fields": [
+			{ "name": "version", "type": "string" }, { "name": "types", "type": "type_def[]" },
+			{ "name": "structs", "type": "struct_def[]" }, { "name": "actions", "type": "action_def[]" },
+			{ "name": "tables", "type": "table_def[]" }, { "name": "ricardian_clauses", "type": "clause_pair[]" },
+			{ "name": "error_messages", "type": "error_message[]$" }, { "name": "abi_extensions", "type": "extension[]$" },
+			{ "name": "variants", "type": "variant_def[]$" } ] }
+	]
+}`
+
+//binary layout of eosio transaction
+const TransactionAbi string = `{
+	"version": "eosio::abi/1.1",
+	"structs": [
+		{ "name": "permission_level", "base": "", "fields": [
+			{ "name": "actor", "type": "name" }, { "name": "permission", "type": "name" } ] },
+		{ "name": "action", "base": "", "fields": [
+			{ "name": "account", "type": "name" }, { "name": "name", "type": "name" },
+			{ "name": "authorization", "type": "permission_level[]" }, { "name": "data", "type": "bytes" } ] },
+		{ "name": "extension", "base": "", "fields": [
+			{ "name": "type", "type": "uint16" }, { "name": "data", "type": "bytes" } ] },
+		{ "name": "transaction", "base": "", "fields": [
+			{ "name": "expiration", "type": "time_point_sec" }, { "name": "ref_block_num", "type": "uint16" },
+			{ "name": "ref_block_prefix", "type": "uint32" }, { "name": "max_net_usage_words", "type": "varuint32" },
+			{ "name": "max_cpu_usage_ms", "type": "uint8" }, { "name": "delay_sec", "type": "varuint32" },
+			{ "name": "context_free_actions", "type": "action[]" }, { "name": "actions", "type": "action[]" },
+			{ "name": "transaction_extensions", "type": "extension[]" } ] }
+	]
+}`
+
+
+type AbiTypeDef struct {
+	NewTypeName string `json:"new_type_name"`
+	Type        string `json:"type"`
+}
+
+type AbiField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type AbiStruct struct {
+	Name       string `json:"name"`
+	Base       string `json:"base"`
+	Fields []AbiField `json:"fields"`
+}
+
+type AbiAction struct {
+	Name              string `json:"name"`
+	Type              string `json:"type"`
+	RicardianContract string `json:"ricardian_contract"`
+}
+
+type AbiTable struct {
+	Name         string `json:"name"`
+	IndexType    string `json:"index_type"`
+	KeyNames   []string `json:"key_names"`
+	KeyTypes   []string `json:"key_types"`
+	Type         string `json:"type"`
+}
+
+type AbiVariant struct {
+	Name      string `json:"name"`
+	Types   []string `json:"types"`
+}
+
+//Abi is a contract abi in eosio json format
+type Abi struct {
+	Version                   string `json:"version"`
+	Types               []AbiTypeDef `json:"types"`
+	Structs              []AbiStruct `json:"structs"`
+	Actions              []AbiAction `json:"actions"`
+	Tables                []AbiTable `json:"tables"`
+	RicardianClauses json.RawMessage `json:"ricardian_clauses,omitempty"`
+	ErrorMessages    json.RawMessage `json:"error_messages,omitempty"`
+	AbiExtensions    json.RawMessage `json:"abi_extensions,omitempty"`
+	Variants            []AbiVariant `json:"variants,omitempty"`
+	typedefs map[string]string
+	structs  map[string]*AbiStruct
+	variants map[string]*AbiVariant
+	actions  map[string]string
+}
+
+
+//built-in abis are parsed in init
+var abiDefAbi *Abi
+var transactionAbi *Abi
+
+
+func mustParseAbi(str string) *Abi {
+	abi, err := parseAbi(json.RawMessage(str))
+	if err != nil {
+		panic(err)
+	}
+	return abi
+}
+
+//parseAbi parses abi stored either as json object, as json string with abi json
+//or as hex encoded binary abi_def
+func parseAbi(raw json.RawMessage) (*Abi, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, errors.New("Abi is empty")
+	}
+	if raw[0] == '"' {
+		var str string
+		err := json.Unmarshal(raw, &str)
+		if err != nil {
+			return nil, err
+		}
+		str = strings.TrimSpace(str)
+		if strings.HasPrefix(str, "{") {
+			return parseAbi(json.RawMessage(str))
+		}
+		data, err := hex.DecodeString(str)
+		if err != nil {
+			return nil, errors.New("Invalid abi")
+		}
+		return decodeAbi(data)
+	}
+	abi := new(Abi)
+	err := json.Unmarshal(raw, abi)
+	if err != nil {
+		return nil, err
+	}
+	abi.init()
+	return abi, nil
+}
+
+//decodeAbi parses binary abi_def
+func decodeAbi(data []byte) (*Abi, error) {
+	if len(data) == 0 {
+		return nil, errors.New("Abi is empty")
+	}
+	decoder := newAbiDecoder(abiDefAbi, data)
+	raw, err := decoder.decode("abi_def")
+	if err != nil {
+		return nil, err
+	}
+	abi := new(Abi)
+	err = json.Unmarshal(raw, abi)
+	if err != nil {
+		return nil, err
+	}
+	abi.init()
+	return abi, nil
+}
+
+//init builds lookup tables
+func (abi *Abi) init() {
+	abi.typedefs = make(map[string]string)
+	abi.structs = make(map[string]*AbiStruct)
+	abi.variants = make(map[string]*AbiVariant)
+	abi.actions = make(map[string]string)
+	for _, typedef := range abi.Types {
+		abi.typedefs[typedef.NewTypeName] = typedef.Type
+	}
+	for i, _ := range abi.Structs {
+		abi.structs[abi.Structs[i].Name] = &abi.Structs[i]
+	}
+	for i, _ := range abi.Variants {
+		abi.variants[abi.Variants[i].Name] = &abi.Variants[i]
+	}
+	for _, action := range abi.Actions {
+		abi.actions[action.Name] = action.Type
+	}
+}
+
+//resolve follows typedefs
+func (abi *Abi) resolve(typeName string) string {
+	for i := 0; i < AbiMaxDepth; i++ {
+		next, ok := abi.typedefs[typeName]
+		if !ok {
+			break
+		}
+		typeName = next
+	}
+	return typeName
+}
+
+//decodeActionData decodes binary data of contract action into json
+func (abi *Abi) decodeActionData(action string, data []byte) (json.RawMessage, error) {
+	typeName, ok := abi.actions[action]
+	if !ok {
+		return nil, errors.New("Action " + action + " is not found in abi")
+	}
+	decoder := newAbiDecoder(abi, data)
+	result, err := decoder.decode(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.pos != len(data) {
+		return nil, errors.New("Unexpected data after action " + action)
+	}
+	return result, nil
+}
+
+
+//abiDecoder converts binary data into json using abi
+//json objects keep the order of struct fields
+type abiDecoder struct {
+	abi   *Abi
+	data  []byte
+	pos   int
+	depth int
+}
+
+func newAbiDecoder(abi *Abi, data []byte) *abiDecoder {
+	return &abiDecoder { abi: abi, data: data }
+}
+
+func (d *abiDecoder) read(size int) ([]byte, error) {
+	if size < 0 || d.pos + size > len(d.data) {
+		return nil, errors.New("Unexpected end of data")
+	}
+	result := d.data[d.pos:d.pos + size]
+	d.pos += size
+	return result, nil
+}
+
+func (d *abiDecoder) readVarUint32() (uint32, error) {
+	var result uint32
+	for shift := uint(0); shift < 35; shift += 7 {
+		b, err := d.read(1)
+		if err != nil {
+			return 0, err
+		}
+		result |= uint32(b[0] & 0x7f) << shift
+		if b[0] & 0x80 == 0 {
+			return result, nil
+		}
+	}
+	return 0, errors.New("Invalid varuint32")
+}
+
+func (d *abiDecoder) readUint64() (uint64, error) {
+	b, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (d *abiDecoder) decode(typeName string) (json.RawMessage, error) {
+	d.depth++
+	defer func () { d.depth-- }()
+	if d.depth > AbiMaxDepth {
+		return nil, errors.New("Abi types are nested too deep")
+	}
+	typeName = d.abi.resolve(typeName)
+
+	if strings.HasSuffix(typeName, "[]") {
+		size, err := d.readVarUint32()
+		if err != nil {
+			return nil, err
+		}
+		if int(size) > len(d.data) - d.pos {
+			return nil, errors.New("Invalid array size")
+		}
+		buf := bytes.NewBufferString("[")
+		for i := 0; i < int(size); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			item, err := d.decode(typeName[:len(typeName) - 2])
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(item)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+	}
+	if strings.HasSuffix(typeName, "?") {
+		flag, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		if flag[0] == 0 {
+			return json.RawMessage("null"), nil
+		}
+		return d.decode(typeName[:len(typeName) - 1])
+	}
+	if decodeBuiltin, ok := abiBuiltinTypes[typeName]; ok {
+		return decodeBuiltin(d)
+	}
+	if variant, ok := d.abi.variants[typeName]; ok {
+		index, err := d.readVarUint32()
+		if err != nil {
+			return nil, err
+		}
+		if int(index) >= len(variant.Types) {
+			return nil, errors.New("Invalid variant index of " + typeName)
+		}
+		value, err := d.decode(variant.Types[index])
+		if err != nil {
+			return nil, err
+		}
+		name, _ := json.Marshal(variant.Types[index])
+		return json.RawMessage("[" + string(name) + "," + string(value) + "]"), nil
+	}
+	if s, ok := d.abi.structs[typeName]; ok {
+		buf := bytes.NewBufferString("{")
+		_, err := d.decodeStruct(s, buf, true)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
+	}
+	return nil, errors.New("Unknown abi type " + typeName)
+}
+
+//decodeStruct writes fields of struct and its base into buf
+//returns false if binary extension field was missing so that no more fields follow
+func (d *abiDecoder) decodeStruct(s *AbiStruct, buf *bytes.Buffer, first bool) (bool, error) {
+	if len(s.Base) > 0 {
+		base, ok := d.abi.structs[d.abi.resolve(s.Base)]
+		if !ok {
+			return false, errors.New("Unknown base struct " + s.Base)
+		}
+		d.depth++
+		if d.depth > AbiMaxDepth {
+			return false, errors.New("Abi types are nested too deep")
+		}
+		more, err := d.decodeStruct(base, buf, first)
+		d.depth--
+		if err != nil || !more {
+			return more, err
+		}
+		first = first && len(buf.Bytes()) == 1
+	}
+	for _, field := range s.Fields {
+		typeName := field.Type
+		if strings.HasSuffix(typeName, "$") {
+			if d.pos == len(d.data) {
+				return false, nil
+			}
+			typeName = typeName[:len(typeName) - 1]
+		}
+		value, err := d.decode(typeName)
+		if err != nil {
+			return false, err
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(field.Name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	return true, nil
+}
+
+
+//abiBuiltinTypes maps built-in abi types to their decoders
+var abiBuiltinTypes map[string]func(*abiDecoder) (json.RawMessage, error)
+
+func init() {
+	abiBuiltinTypes = map[string]func(*abiDecoder) (json.RawMessage, error) {
+		"bool": func(d *abiDecoder) (json.RawMessage, error) {
+			b, err := d.read(1)
+			if err != nil {
+				return nil, err
+			}
+			if b[0] == 0 {
+				return json.RawMessage("false"), nil
+			}
+			return json.RawMessage("true"), nil
+		},
+		"int8": decodeInt(1, true),
+		"uint8": decodeInt(1, false),
+		"int16": decodeInt(2, true),
+		"uint16": decodeInt(2, false),
+		"int32": decodeInt(4, true),
+		"uint32": decodeInt(4, false),
+		"int64": decodeInt(8, true),
+		"uint64": decodeInt(8, false),
+		"int128": decodeInt128(true),
+		"uint128": decodeInt128(false),
+		"varuint32": func(d *abiDecoder) (json.RawMessage, error) {
+			value, err := d.readVarUint32()
+			if err != nil {
+				return nil, err
+			}
+			return json.RawMessage(strconv.FormatUint(uint64(value), 10)), nil
+		},
+		"varint32": func(d *abiDecoder) (json.RawMessage, error) {
+			value, err := d.readVarUint32()
+			if err != nil {
+				return nil, err
+			}
+			//zigzag encoding
+			result := int32(value >> 1) ^ -int32(value & 1)
+			return json.RawMessage(strconv.FormatInt(int64(result), 10)), nil
+		},
+		"float32": func(d *abiDecoder) (json.RawMessage, error) {
+			b, err := d.read(4)
+			if err != nil {
+				return nil, err
+			}
+			return formatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 32), nil
+		},
+		"float64": func(d *abiDecoder) (json.RawMessage, error) {
+			b, err := d.read(8)
+			if err != nil {
+				return nil, err
+			}
+			return formatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 64), nil
+		},
+		"float128": decodeHex(16),
+		"time_point": func(d *abiDecoder) (json.RawMessage, error) {
+			b, err := d.read(8)
+			if err != nil {
+				return nil, err
+			}
+			us := int64(binary.LittleEndian.Uint64(b))
+			t := time.Unix(us / 1000000, (us % 1000000) * 1000).UTC()
+			return json.Marshal(t.Format("2006-01-02T15:04:05.000"))
+		},
+		"time_point_sec": func(d *abiDecoder) (json.RawMessage, error) {
+			b, err := d.read(4)
+			if err != nil {
+				return nil, err
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(b)), 0).UTC()
+			return json.Marshal(t.Format("2006-01-02T15:04:05"))
+		},
+		"block_timestamp_type": func(d *abiDecoder) (json.RawMessage, error) {
+			b, err := d.read(4)
+			if err != nil {
+				return nil, err
+			}
+			//half-second slots since 2000-01-01
+			ms := int64(binary.LittleEndian.Uint32(b)) * 500 + 946684800000
+			t := time.Unix(ms / 1000, (ms % 1000) * 1000000).UTC()
+			return json.Marshal(t.Format("2006-01-02T15:04:05.000"))
+		},
+		"name": func(d *abiDecoder) (json.RawMessage, error) {
+			value, err := d.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(nameToString(value))
+		},
+		"bytes": func(d *abiDecoder) (json.RawMessage, error) {
+			size, err := d.readVarUint32()
+			if err != nil {
+				return nil, err
+			}
+			b, err := d.read(int(size))
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(hex.EncodeToString(b))
+		},
+		"string": func(d *abiDecoder) (json.RawMessage, error) {
+			size, err := d.readVarUint32()
+			if err != nil {
+				return nil, err
+			}
+			b, err := d.read(int(size))
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(string(b))
+		},
+		"checksum160": decodeHex(20),
+		"checksum256": decodeHex(32),
+		"checksum512": decodeHex(64),
+		"public_key": func(d *abiDecoder) (json.RawMessage, error) {
+			keyType, err := d.readVarUint32()
+			if err != nil {
+				return nil, err
+			}
+			key, err := d.read(PublicKeySize)
+			if err != nil {
+				return nil, err
+			}
+			str, err := formatPublicKey(int(keyType), key)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(str)
+		},
+		"signature": func(d *abiDecoder) (json.RawMessage, error) {
+			sigType, err := d.readVarUint32()
+			if err != nil {
+				return nil, err
+			}
+			sig, err := d.read(SignatureSize)
+			if err != nil {
+				return nil, err
+			}
+			str, err := formatSignature(int(sigType), sig)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(str)
+		},
+		"symbol": func(d *abiDecoder) (json.RawMessage, error) {
+			value, err := d.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			precision, code := splitSymbol(value)
+			return json.Marshal(strconv.Itoa(precision) + "," + code)
+		},
+		"symbol_code": func(d *abiDecoder) (json.RawMessage, error) {
+			value, err := d.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(symbolCodeToString(value))
+		},
+		"asset": func(d *abiDecoder) (json.RawMessage, error) {
+			asset, err := d.readAsset()
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(asset.String())
+		},
+		"extended_asset": func(d *abiDecoder) (json.RawMessage, error) {
+			asset, err := d.readAsset()
+			if err != nil {
+				return nil, err
+			}
+			contract, err := d.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(struct {
+				Quantity string `json:"quantity"`
+				Contract string `json:"contract"`
+			}{ asset.String(), nameToString(contract) })
+		},
+	}
+	abiDefAbi = mustParseAbi(AbiDefAbi)
+	transactionAbi = mustParseAbi(TransactionAbi)
+}
+
+func decodeInt(size int, signed bool) func(*abiDecoder) (json.RawMessage, error) {
+	return func(d *abiDecoder) (json.RawMessage, error) {
+		b, err := d.read(size)
+		if err != nil {
+			return nil, err
+		}
+		var value uint64
+		for i := size - 1; i >= 0; i-- {
+			value = value << 8 | uint64(b[i])
+		}
+		if signed {
+			//sign extension
+			shift := uint(64 - size * 8)
+			return json.RawMessage(strconv.FormatInt(int64(value << shift) >> shift, 10)), nil
+		}
+		return json.RawMessage(strconv.FormatUint(value, 10)), nil
+	}
+}
+
+func decodeInt128(signed bool) func(*abiDecoder) (json.RawMessage, error) {
+	return func(d *abiDecoder) (json.RawMessage, error) {
+		b, err := d.read(16)
+		if err != nil {
+			return nil, err
+		}
+		bigEndian := make([]byte, 16)
+		for i, _ := range b {
+			bigEndian[15 - i] = b[i]
+		}
+		value := new(big.Int).SetBytes(bigEndian)
+		if signed && bigEndian[0] & 0x80 != 0 {
+			value.Sub(value, new(big.Int).Lsh(big.NewInt(1), 128))
+		}
+		return json.Marshal(value.String())
+	}
+}
+
+func decodeHex(size int) func(*abiDecoder) (json.RawMessage, error) {
+	return func(d *abiDecoder) (json.RawMessage, error) {
+		b, err := d.read(size)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(hex.EncodeToString(b))
+	}
+}
+
+//formatFloat returns json number, special values which can't be represented in json are returned as strings
+func formatFloat(value float64, bitSize int) json.RawMessage {
+	str := strconv.FormatFloat(value, 'g', -1, bitSize)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		b, _ := json.Marshal(str)
+		return b
+	}
+	return json.RawMessage(str)
+}
+
+func (d *abiDecoder) readAsset() (Asset, error) {
+	var asset Asset
+	b, err := d.read(8)
+	if err != nil {
+		return asset, err
+	}
+	asset.Amount = int64(binary.LittleEndian.Uint64(b))
+	symbol, err := d.readUint64()
+	if err != nil {
+		return asset, err
+	}
+	asset.Precision, asset.Symbol = splitSymbol(symbol)
+	return asset, nil
+}
+
+
+//nameToString converts eosio name to string
+func nameToString(value uint64) string {
+	const charmap = ".12345abcdefghijklmnopqrstuvwxyz"
+	str := make([]byte, 13)
+	for i := 0; i <= 12; i++ {
+		if i == 0 {
+			str[12 - i] = charmap[value & 0x0f]
+			value >>= 4
+		} else {
+			str[12 - i] = charmap[value & 0x1f]
+			value >>= 5
+		}
+	}
+	return strings.TrimRight(string(str), ".")
+}
+
+//splitSymbol returns precision and code of symbol
+func splitSymbol(value uint64) (int, string) {
+	return int(value & 0xff), symbolCodeToString(value >> 8)
+}
+
+func symbolCodeToString(value uint64) string {
+	result := make([]byte, 0, 7)
+	for value > 0 {
+		result = append(result, byte(value & 0xff))
+		value >>= 8
+	}
+	return string(result)
+}
+
+
+//unpackTransactionData returns packed transaction and context free data decompressed if needed
+func unpackTransactionData(trx *TransactionFromBlock) ([]byte, []byte, error) {
+	var compression interface{}
+	json.Unmarshal(trx.Compression, &compression)
+	zlibCompressed := compression == "zlib" || compression == float64(1)
+	unpack := func(raw json.RawMessage) ([]byte, error) {
+		var packedHex string
+		if len(raw) > 0 && string(raw) != "null" {
+			err := json.Unmarshal(raw, &packedHex)
+			if err != nil {
+				return nil, err
+			}
+		}
+		packed, err := hex.DecodeString(packedHex)
+		if err != nil {
+			return nil, err
+		}
+		if !zlibCompressed || len(packed) == 0 {
+			return packed, nil
+		}
+		reader, err := zlib.NewReader(bytes.NewReader(packed))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	}
+	packedTrx, err := unpack(trx.PackedTrx)
+	if err != nil {
+		return nil, nil, err
+	}
+	contextFreeData, err := unpack(trx.PackedContextFreeData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return packedTrx, contextFreeData, nil
+}
+
+
+//TransactionAction is transaction action in get_transaction format
+type TransactionAction struct {
+	Account                string `json:"account"`
+	Name                   string `json:"name"`
+	Authorization json.RawMessage `json:"authorization"`
+	Data          json.RawMessage `json:"data"`
+	HexData                string `json:"hex_data,omitempty"`
+}
+
+//hasData checks if action data is already decoded
+func (a *TransactionAction) hasData() bool {
+	return len(a.Data) > 0 && string(a.Data) != "null"
+}
+
+//decodeData decodes hex_data with contract abi
+//if there is no abi or data doesn't match it, hex_data is used as data like nodeos does
+func (a *TransactionAction) decodeData(abis map[string]*Abi) {
+	if a.hasData() || len(a.HexData) == 0 {
+		return
+	}
+	if abi := abis[a.Account]; abi != nil {
+		data, err := hex.DecodeString(a.HexData)
+		if err == nil {
+			decoded, err := abi.decodeActionData(a.Name, data)
+			if err == nil {
+				a.Data = decoded
+				return
+			}
+		}
+	}
+	a.Data, _ = json.Marshal(a.HexData)
+}
+
+//unpackTransaction deserializes packed transaction into trx in get_transaction format
+//returns trx fields and pointers to its actions (context free ones first) which data is not decoded yet
+func unpackTransaction(trx *TransactionFromBlock) (map[string]json.RawMessage, []*TransactionAction, []*TransactionAction, error) {
+	packedTrx, packedContextFreeData, err := unpackTransactionData(trx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	decoder := newAbiDecoder(transactionAbi, packedTrx)
+	raw, err := decoder.decode("transaction")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	result := make(map[string]json.RawMessage)
+	err = json.Unmarshal(raw, &result)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var contextFreeActions, actions []*TransactionAction
+	for _, field := range []string{ "context_free_actions", "actions" } {
+		var tmp []struct {
+			Account                string `json:"account"`
+			Name                   string `json:"name"`
+			Authorization json.RawMessage `json:"authorization"`
+			Data                   string `json:"data"`
+		}
+		err = json.Unmarshal(result[field], &tmp)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		list := make([]*TransactionAction, 0, len(tmp))
+		for _, action := range tmp {
+			list = append(list, &TransactionAction { Account: action.Account, Name: action.Name,
+				Authorization: action.Authorization, HexData: action.Data })
+		}
+		if field == "actions" {
+			actions = list
+		} else {
+			contextFreeActions = list
+		}
+	}
+	result["context_free_data"] = json.RawMessage("[]")
+	if len(packedContextFreeData) > 0 {
+		decoder = newAbiDecoder(transactionAbi, packedContextFreeData)
+		result["context_free_data"], err = decoder.decode("bytes[]")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	result["signatures"] = trx.Signatures
+	if len(result["signatures"]) == 0 {
+		result["signatures"] = json.RawMessage("[]")
+	}
+	return result, contextFreeActions, actions, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultAbiHistoryCacheSize  int = 10000
+const AbiHistoryRefreshSeconds  int64 = 10
+const AbiHistoryBatchSize         int = 100
+
+
+//AbiVersion is contract abi set by one eosio::setabi action
+//Abi is nil if the action cleared contract abi
+type AbiVersion struct {
+	BlockNum           uint64
+	Seq                uint64
+	TrxId              string
+	BlockTime json.RawMessage
+	Abi                  *Abi
+}
+
+//abiHistoryEntry holds known abi versions of one contract in ascending order
+type abiHistoryEntry struct {
+	Versions  []AbiVersion
+	MaxSeq    uint64
+	CheckedAt time.Time
+}
+
+//AbiHistory reconstructs abi versions of contracts from eosio::setabi actions in action_traces indices
+//versions are cached per contract and refreshed incrementally: only setabi actions
+//with global sequence greater than the last known one are requested
+type AbiHistory struct {
+	Client     *elastic.Client
+	GetIndices func() map[string][]string
+	Entries    map[string]*abiHistoryEntry
+	MaxSize    int
+	Mutex      sync.Mutex
+}
+
+
+func newAbiHistory(client *elastic.Client, getIndices func() map[string][]string, maxSize int) *AbiHistory {
+	if maxSize <= 0 {
+		maxSize = DefaultAbiHistoryCacheSize
+	}
+	history := new(AbiHistory)
+	history.Client = client
+	history.GetIndices = getIndices
+	history.Entries = make(map[string]*abiHistoryEntry)
+	history.MaxSize = maxSize
+	return history
+}
+
+//versions returns all known abi versions of account in ascending order
+func (h *AbiHistory) versions(account string) ([]AbiVersion, error) {
+	h.Mutex.Lock()
+	var versions []AbiVersion
+	var maxSeq uint64
+	entry, ok := h.Entries[account]
+	if ok {
+		versions = entry.Versions
+		maxSeq = entry.MaxSeq
+		if time.Since(entry.CheckedAt) < time.Duration(AbiHistoryRefreshSeconds) * time.Second {
+			h.Mutex.Unlock()
+			return versions, nil
+		}
+	}
+	h.Mutex.Unlock()
+
+	newVersions, newMaxSeq, err := searchAbiVersions(h.Client, h.GetIndices()[ActionTracesIndexPrefix], account, maxSeq)
+	if err != nil && ok {
+		//keep using outdated versions
+		return versions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(newVersions) > 0 {
+		//versions slice may be shared with readers, never append to it in place
+		versions = append(append(make([]AbiVersion, 0, len(versions) + len(newVersions)), versions...), newVersions...)
+	}
+	if newMaxSeq > maxSeq {
+		maxSeq = newMaxSeq
+	}
+
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+	if current, ok := h.Entries[account]; ok && current.MaxSeq > maxSeq {
+		//refreshed concurrently
+		return current.Versions, nil
+	}
+	if _, ok := h.Entries[account]; !ok && len(h.Entries) >= h.MaxSize {
+		//evict an arbitrary entry
+		for k, _ := range h.Entries {
+			delete(h.Entries, k)
+			break
+		}
+	}
+	h.Entries[account] = &abiHistoryEntry { Versions: versions, MaxSeq: maxSeq, CheckedAt: time.Now() }
+	return versions, nil
+}
+
+//abiAt returns abi version of account which was active at given block
+//if seq is not zero, it is used instead of block number to select version
+//so that actions in the same block as setabi are decoded correctly
+//returns nil if there was no setabi before
+func (h *AbiHistory) abiAt(account string, blockNum uint64, seq uint64) (*AbiVersion, error) {
+	versions, err := h.versions(account)
+	if err != nil {
+		return nil, err
+	}
+	var result *AbiVersion
+	for i, _ := range versions {
+		if (seq > 0 && versions[i].Seq < seq) || (seq == 0 && versions[i].BlockNum <= blockNum) {
+			result = &versions[i]
+		} else {
+			break
+		}
+	}
+	return result, nil
+}
+
+//hasHistory checks if any setabi action of account is indexed
+func (h *AbiHistory) hasHistory(account string) (bool, error) {
+	versions, err := h.versions(account)
+	if err != nil {
+		return false, err
+	}
+	return len(versions) > 0, nil
+}
+
+
+//searchAbiVersions returns abi versions of account set after afterSeq
+//and the greatest global sequence among found setabi actions
+func searchAbiVersions(client *elastic.Client, indices []string, account string, afterSeq uint64) ([]AbiVersion, uint64, error) {
+	//data of setabi may be not indexed, setabi is always authorized by the account itself
+	accountQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1).
+		Should(elastic.NewMatchQuery("act.data.account", account)).
+		Should(elastic.NewMatchQuery("act.authorization.actor", account))
+	query := newSystemActionQuery("setabi").Filter(accountQuery)
+	result := make([]AbiVersion, 0)
+	maxSeq := afterSeq
+	for {
+		page, err := searchActionsAfter(client, indices, query, maxSeq, AbiHistoryBatchSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		maxSeq = page.LastSeq
+		for _, action := range page.Actions {
+			//abi which can't be parsed is kept as a version without abi
+			//so that actions after it are not decoded with the previous one
+			setAbiAccount, abi, _ := parseSetAbi(action.Trace)
+			if setAbiAccount != account {
+				continue
+			}
+			blockNum, _ := parseUint64(action.Trace.BlockNum)
+			result = append(result, AbiVersion { BlockNum: blockNum, Seq: action.Seq,
+				TrxId: action.Trace.TrxId, BlockTime: action.Trace.BlockTime, Abi: abi })
+		}
+		if page.Hits < AbiHistoryBatchSize {
+			return result, maxSeq, nil
+		}
+	}
+}
+
+//parseSetAbi parses data of eosio::setabi action
+//returns account and its new abi, nil abi means abi was cleared
+func parseSetAbi(trace *ActionTrace) (string, *Abi, error) {
+	var hexData string
+	json.Unmarshal(trace.Act.HexData, &hexData)
+	if len(hexData) == 0 {
+		//hex_data is not indexed, use json data
+		var data struct {
+			Account           string `json:"account"`
+			Abi      json.RawMessage `json:"abi"`
+		}
+		err := json.Unmarshal(trace.Act.Data, &data)
+		if err != nil || len(data.Account) == 0 {
+			return "", nil, errors.New("Invalid setabi data")
+		}
+		if string(data.Abi) == `""` {
+			return data.Account, nil, nil
+		}
+		abi, err := parseAbi(data.Abi)
+		return data.Account, abi, err
+	}
+	data, err := hex.DecodeString(hexData)
+	if err != nil {
+		return "", nil, errors.New("Invalid setabi data")
+	}
+	decoder := newAbiDecoder(nil, data)
+	account, err := decoder.readUint64()
+	if err != nil {
+		return "", nil, err
+	}
+	size, err := decoder.readVarUint32()
+	if err != nil {
+		return "", nil, err
+	}
+	abiData, err := decoder.read(int(size))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(abiData) == 0 {
+		return nameToString(account), nil, nil
+	}
+	abi, err := decodeAbi(abiData)
+	return nameToString(account), abi, err
+}
+
+
+//getAbiSnapshot returns abi of account which was active at given block (the latest one if block is not set)
+//abi is null if it was cleared
+func getAbiSnapshot(history *AbiHistory, params GetAbiSnapshotParams) (*GetAbiSnapshotResult, *ErrorWithCode) {
+	if len(params.Account) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account is required"))
+	}
+	blockNum := uint64(math.MaxUint64)
+	if params.BlockNum != nil {
+		blockNum = *params.BlockNum
+	}
+	version, err := history.abiAt(params.Account, blockNum, 0)
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	if version == nil {
+		return nil, newErrorWithCode(404, errors.New("Abi not found"))
+	}
+	result := new(GetAbiSnapshotResult)
+	result.Account = params.Account
+	result.BlockNum = version.BlockNum
+	result.BlockTime = version.BlockTime
+	result.GlobalActionSeq = version.Seq
+	result.TrxId = version.TrxId
+	result.Abi = version.Abi
+	return result, nil
+}
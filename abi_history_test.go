@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+
+func TestAbiAt(t *testing.T) {
+	abi := mustParseAbi(testAbi)
+	history := newAbiHistory(nil, nil, 0)
+	//fresh entry is used without searching ES
+	history.Entries["eosio.token"] = &abiHistoryEntry { MaxSeq: 300, CheckedAt: time.Now(), Versions: []AbiVersion {
+		{ BlockNum: 10, Seq: 100, Abi: abi },
+		{ BlockNum: 20, Seq: 200, Abi: nil },
+		{ BlockNum: 20, Seq: 250, Abi: abi },
+	} }
+	tests := []struct {
+		name     string
+		blockNum uint64
+		seq      uint64
+		expected uint64
+	}{
+		{ "before the first setabi", 5, 0, 0 },
+		{ "block of the first setabi", 10, 0, 100 },
+		{ "between setabi blocks", 15, 0, 100 },
+		{ "last setabi of the block", 20, 0, 250 },
+		{ "after the last setabi", 1000, 0, 250 },
+		{ "action before the first setabi", 10, 90, 0 },
+		{ "action of the same block before abi is cleared", 20, 150, 100 },
+		{ "action between setabi of the same block", 20, 220, 200 },
+		{ "action after the last setabi", 20, 260, 250 },
+		{ "setabi action itself uses the previous abi", 20, 250, 200 },
+	}
+	for _, test := range tests {
+		version, err := history.abiAt("eosio.token", test.blockNum, test.seq)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		var seq uint64
+		if version != nil {
+			seq = version.Seq
+		}
+		if seq != test.expected {
+			t.Errorf("%s: expected version %d, got %d", test.name, test.expected, seq)
+		}
+	}
+}
+
+func TestTransactionActionSeqs(t *testing.T) {
+	traces := `[
+		{ "receipt": { "global_sequence": 11 }, "act": { "account": "eosio.token", "name": "transfer" } },
+		{ "receipt": { "global_sequence": "12" }, "act": { "account": "eosio", "name": "buyram" } },
+		{ "receipt": { "global_sequence": 13 }, "act": { "account": "eosio.token", "name": "transfer" } }
+	]`
+	transfer := &TransactionAction{ Account: "eosio.token", Name: "transfer" }
+	buyram := &TransactionAction{ Account: "eosio", Name: "buyram" }
+	issue := &TransactionAction{ Account: "eosio.token", Name: "issue" }
+	tests := []struct {
+		name     string
+		traces   string
+		actions  []*TransactionAction
+		expected []uint64
+	}{
+		{ "same order", traces, []*TransactionAction{ transfer, buyram, transfer }, []uint64{ 11, 12, 13 } },
+		{ "other order", traces, []*TransactionAction{ buyram, transfer, transfer }, []uint64{ 12, 11, 13 } },
+		{ "unmatched action", traces, []*TransactionAction{ transfer, issue }, []uint64{ 11, 0 } },
+		{ "more actions than traces", traces, []*TransactionAction{ buyram, buyram }, []uint64{ 12, 0 } },
+		{ "invalid traces", "{", []*TransactionAction{ transfer }, []uint64{ 0 } },
+		{ "no traces", "", []*TransactionAction{ transfer }, []uint64{ 0 } },
+	}
+	for _, test := range tests {
+		result := transactionActionSeqs([]byte(test.traces), test.actions)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, result)
+		}
+	}
+}
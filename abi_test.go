@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+
+//testAbi covers typedefs, base structs, variants, optional, array and binary extension types
+const testAbi string = `{
+	"version": "eosio::abi/1.1",
+	"types": [
+		{ "new_type_name": "account_name", "type": "name" }
+	],
+	"structs": [
+		{ "name": "transfer", "base": "", "fields": [
+			{ "name": "from", "type": "account_name" },
+			{ "name": "to", "type": "account_name" },
+			{ "name": "quantity", "type": "asset" },
+			{ "name": "memo", "type": "string" }
+		] },
+		{ "name": "parent", "base": "", "fields": [
+			{ "name": "y", "type": "uint8" }
+		] },
+		{ "name": "child", "base": "parent", "fields": [
+			{ "name": "z", "type": "uint8" }
+		] },
+		{ "name": "extended", "base": "", "fields": [
+			{ "name": "a", "type": "uint8" },
+			{ "name": "b", "type": "uint16$" },
+			{ "name": "c", "type": "string$" }
+		] },
+		{ "name": "extended_child", "base": "extended", "fields": [
+			{ "name": "d", "type": "uint8" }
+		] },
+		{ "name": "containers", "base": "", "fields": [
+			{ "name": "list", "type": "uint8[]" },
+			{ "name": "maybe", "type": "uint8?" }
+		] }
+	],
+	"actions": [
+		{ "name": "transfer", "type": "transfer", "ricardian_contract": "" }
+	],
+	"tables": [],
+	"variants": [
+		{ "name": "value", "types": [ "uint8", "string", "child" ] }
+	]
+}`
+
+
+func TestNameToString(t *testing.T) {
+	tests := []struct {
+		value    uint64
+		expected string
+	}{
+		{ 0, "" },
+		{ 6138663577826885632, "eosio" },
+		{ 6138663591592764928, "eosio.token" },
+		{ 6138663590285017088, "eosio.ram" },
+		{ 3773036822876127232, "alice" },
+		{ 3617214756542218240, "active" },
+		{ 1, "............1" },
+		{ 18446744073709551615, "zzzzzzzzzzzzj" },
+	}
+	for _, test := range tests {
+		result := nameToString(test.value)
+		if result != test.expected {
+			t.Errorf("%d: expected %q, got %q", test.value, test.expected, result)
+		}
+	}
+}
+
+func TestAbiDecoder(t *testing.T) {
+	abi := mustParseAbi(testAbi)
+	tests := []struct {
+		name     string
+		typeName string
+		data     string
+		expected string
+	}{
+		{ "struct with typedefs and asset", "transfer",
+			"0000000000ea3055" + "00a6823403ea3055" + "1027000000000000" + "04454f5300000000" + "026869",
+			`{"from":"eosio","to":"eosio.token","quantity":"1.0000 EOS","memo":"hi"}` },
+		{ "base struct fields first", "child", "0506", `{"y":5,"z":6}` },
+		{ "variant first type", "value", "0007", `["uint8",7]` },
+		{ "variant string", "value", "01026869", `["string","hi"]` },
+		{ "variant struct", "value", "020102", `["child",{"y":1,"z":2}]` },
+		{ "binary extensions missing", "extended", "01", `{"a":1}` },
+		{ "binary extension partially present", "extended", "010200", `{"a":1,"b":2}` },
+		{ "binary extensions present", "extended", "0102000178", `{"a":1,"b":2,"c":"x"}` },
+		{ "missing extension of base ends struct", "extended_child", "01", `{"a":1}` },
+		{ "array and missing optional", "containers", "02010200", `{"list":[1,2],"maybe":null}` },
+		{ "empty array and present optional", "containers", "000109", `{"list":[],"maybe":9}` },
+	}
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		decoder := newAbiDecoder(abi, data)
+		result, err := decoder.decode(test.typeName)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if string(result) != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, result)
+		}
+		if decoder.pos != len(data) {
+			t.Errorf("%s: %d bytes left", test.name, len(data) - decoder.pos)
+		}
+	}
+}
+
+func TestAbiDecoderErrors(t *testing.T) {
+	abi := mustParseAbi(testAbi)
+	tests := []struct {
+		name     string
+		typeName string
+		data     string
+	}{
+		{ "truncated struct", "child", "05" },
+		{ "invalid variant index", "value", "0307" },
+		{ "array size beyond data", "containers", "0501" },
+		{ "unknown type", "unknown", "00" },
+		{ "empty data", "transfer", "" },
+	}
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		if _, err := newAbiDecoder(abi, data).decode(test.typeName); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestDecodeActionData(t *testing.T) {
+	abi := mustParseAbi(testAbi)
+	transfer := "0000000000ea3055" + "00a6823403ea3055" + "1027000000000000" + "04454f5300000000" + "00"
+	tests := []struct {
+		name     string
+		action   string
+		data     string
+		expected string
+	}{
+		{ "transfer", "transfer", transfer, `{"from":"eosio","to":"eosio.token","quantity":"1.0000 EOS","memo":""}` },
+		{ "trailing data", "transfer", transfer + "00", "" },
+		{ "unknown action", "issue", transfer, "" },
+	}
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		result, err := abi.decodeActionData(test.action, data)
+		if len(test.expected) == 0 {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if string(result) != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, result)
+		}
+	}
+}
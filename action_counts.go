@@ -0,0 +1,192 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultActionCountCacheSize int = 100000
+//ActionCountSettleMs is the time given to ES to index actions below the highest seen global_sequence
+//before they are added to the cached count of the newest index
+const ActionCountSettleMs         int64 = 5000
+
+
+//ActionCount holds number of account actions in one index
+//count of the newest index includes only actions with global_sequence up to Seq,
+//actions up to Pending are added to it once they had ActionCountSettleMs to be indexed
+type ActionCount struct {
+	Count     int64
+	Seq       uint64
+	Pending   uint64
+	PendingAt time.Time
+}
+
+//ActionCountCache keeps number of actions per account per action_traces index
+//counts of older indices are final, the newest index is counted incrementally
+//above the last settled global_sequence because ES may index its actions out of order
+//the least recently used entry is evicted when the cache is full,
+//the cache is cleared when the list of action_traces indices changes
+type ActionCountCache struct {
+	Entries    map[string]*actionCountEntry
+	//Recent holds keys of Entries, the most recently used first
+	Recent     *list.List
+	Generation uint64
+	MaxSize    int
+	Mutex      sync.Mutex
+}
+
+type actionCountEntry struct {
+	Counts  map[string]ActionCount
+	Element *list.Element
+}
+
+
+func newActionCountCache(maxSize int) *ActionCountCache {
+	if maxSize <= 0 {
+		maxSize = DefaultActionCountCacheSize
+	}
+	cache := new(ActionCountCache)
+	cache.Entries = make(map[string]*actionCountEntry)
+	cache.Recent = list.New()
+	cache.MaxSize = maxSize
+	return cache
+}
+
+//actionCountKey returns cache key for actions matching request params
+//every filter that changes the set of matched actions is a part of the key
+func actionCountKey(params GetActionsParams) string {
+	match := params.Match
+	if len(match) == 0 {
+		match = MatchAny
+	}
+	blockNumFrom, blockNumTo := "", ""
+	if params.BlockNumFrom != nil {
+		blockNumFrom = strconv.FormatUint(*params.BlockNumFrom, 10)
+	}
+	if params.BlockNumTo != nil {
+		blockNumTo = strconv.FormatUint(*params.BlockNumTo, 10)
+	}
+	return strings.Join([]string{ params.AccountName, match, params.Contract, params.ActionName,
+		params.After, params.Before, blockNumFrom, blockNumTo }, "|")
+}
+
+//invalidate drops all cached counts
+//counts computed concurrently with the old list of indices are not stored after that
+func (c *ActionCountCache) invalidate() {
+	c.Mutex.Lock()
+	c.Entries = make(map[string]*actionCountEntry)
+	c.Recent.Init()
+	c.Generation++
+	c.Mutex.Unlock()
+}
+
+//get returns number of actions matching params for every index in indices
+//indices must be in ascending order: all indices except the newest one are counted once,
+//only actions above the settled global_sequence are counted in the newest index
+func (c *ActionCountCache) get(client *elastic.Client, params GetActionsParams, indices []string) ([]int64, error) {
+	if len(indices) == 0 {
+		return []int64{}, nil
+	}
+	key := actionCountKey(params)
+	c.Mutex.Lock()
+	generation := c.Generation
+	cached := make(map[string]ActionCount)
+	if entry, ok := c.Entries[key]; ok {
+		for index, count := range entry.Counts {
+			cached[index] = count
+		}
+		c.Recent.MoveToFront(entry.Element)
+	}
+	c.Mutex.Unlock()
+
+	newest := indices[len(indices) - 1]
+	newestCount := cached[newest]
+	settle := newestCount.Pending > newestCount.Seq &&
+		time.Since(newestCount.PendingAt) >= time.Duration(ActionCountSettleMs) * time.Millisecond
+	msearch := client.MultiSearch()
+	countIndices := make([]string, 0)
+	for _, index := range indices[:len(indices) - 1] {
+		if _, ok := cached[index]; !ok {
+			countIndices = append(countIndices, index)
+			msearch.Add(elastic.NewSearchRequest().Index(index).Query(newActionsQuery(params)).Size(0))
+		}
+	}
+	//actions above the settled global_sequence, the highest of them becomes pending
+	msearch.Add(elastic.NewSearchRequest().Index(newest).Size(0).
+		Query(newActionsQuery(params).Filter(elastic.NewRangeQuery("global_sequence").Gt(newestCount.Seq))).
+		Aggregation("max_global_sequence", elastic.NewMaxAggregation().Field("global_sequence")))
+	if settle {
+		msearch.Add(elastic.NewSearchRequest().Index(newest).Size(0).
+			Query(newActionsQuery(params).Filter(elastic.NewRangeQuery("global_sequence").
+				Gt(newestCount.Seq).Lte(newestCount.Pending))))
+	}
+	expected := len(countIndices) + 1
+	if settle {
+		expected++
+	}
+	msearchResult, err := msearch.Do(context.Background())
+	if err != nil || msearchResult == nil || len(msearchResult.Responses) != expected {
+		if err == nil {
+			err = errors.New("Failed to count actions")
+		}
+		return nil, err
+	}
+	for _, resp := range msearchResult.Responses {
+		if resp == nil || resp.Error != nil || resp.Hits == nil {
+			return nil, errors.New("Failed to count actions")
+		}
+	}
+	for i, index := range countIndices {
+		cached[index] = ActionCount{ Count: msearchResult.Responses[i].Hits.TotalHits }
+	}
+	above := msearchResult.Responses[len(countIndices)]
+	newestTotal := newestCount.Count + above.Hits.TotalHits
+	if settle {
+		newestCount.Count += msearchResult.Responses[len(countIndices) + 1].Hits.TotalHits
+		newestCount.Seq = newestCount.Pending
+	}
+	if newestCount.Pending <= newestCount.Seq {
+		if max, ok := above.Aggregations.Max("max_global_sequence"); ok && max.Value != nil && uint64(*max.Value) > newestCount.Seq {
+			newestCount.Pending = uint64(*max.Value)
+			newestCount.PendingAt = time.Now()
+		}
+	}
+	cached[newest] = newestCount
+	c.store(key, cached, generation)
+
+	result := make([]int64, 0, len(indices))
+	for _, index := range indices[:len(indices) - 1] {
+		result = append(result, cached[index].Count)
+	}
+	result = append(result, newestTotal)
+	return result, nil
+}
+
+//store caches counts of key evicting the least recently used entry if the cache is full
+func (c *ActionCountCache) store(key string, counts map[string]ActionCount, generation uint64) {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	if generation != c.Generation {
+		//counted with outdated list of indices
+		return
+	}
+	if entry, ok := c.Entries[key]; ok {
+		entry.Counts = counts
+		c.Recent.MoveToFront(entry.Element)
+		return
+	}
+	if len(c.Entries) >= c.MaxSize {
+		if oldest := c.Recent.Back(); oldest != nil {
+			delete(c.Entries, oldest.Value.(string))
+			c.Recent.Remove(oldest)
+		}
+	}
+	c.Entries[key] = &actionCountEntry { Counts: counts, Element: c.Recent.PushFront(key) }
+}
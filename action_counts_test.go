@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+
+func TestActionCountCacheEviction(t *testing.T) {
+	cache := newActionCountCache(2)
+	counts := map[string]ActionCount{ "action_traces-1": { Count: 1 } }
+	cache.store("a", counts, 0)
+	cache.store("b", counts, 0)
+	//using a makes b the least recently used entry
+	cache.Recent.MoveToFront(cache.Entries["a"].Element)
+	cache.store("c", counts, 0)
+	tests := []struct {
+		key    string
+		cached bool
+	}{
+		{ "a", true },
+		{ "b", false },
+		{ "c", true },
+	}
+	for _, test := range tests {
+		if _, ok := cache.Entries[test.key]; ok != test.cached {
+			t.Errorf("%s: expected cached %v, got %v", test.key, test.cached, ok)
+		}
+	}
+	if cache.Recent.Len() != len(cache.Entries) {
+		t.Errorf("expected %d recent keys, got %d", len(cache.Entries), cache.Recent.Len())
+	}
+
+	//counts of an outdated generation are not stored
+	cache.invalidate()
+	cache.store("a", counts, 0)
+	if len(cache.Entries) != 0 || cache.Recent.Len() != 0 {
+		t.Errorf("expected empty cache after invalidate, got %d entries", len(cache.Entries))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+
+//Asset is eosio asset with amount stored in the smallest units
+//e.g. "1.0500 EOS" is Asset{ Amount: 10500, Precision: 4, Symbol: "EOS" }
+type Asset struct {
+	Amount    int64
+	Precision int
+	Symbol    string
+}
+
+//assetAmountFormat matches amount of asset string: optional minus, digits and optional fraction
+var assetAmountFormat = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+
+//parseAsset parses asset string like "1.0500 EOS"
+func parseAsset(str string) (Asset, error) {
+	var asset Asset
+	parts := strings.Fields(str)
+	if len(parts) != 2 {
+		return asset, errors.New("Invalid asset: " + str)
+	}
+	asset.Symbol = parts[1]
+	amount := parts[0]
+	if !assetAmountFormat.MatchString(amount) {
+		return asset, errors.New("Invalid asset: " + str)
+	}
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+	if dot := strings.Index(amount, "."); dot >= 0 {
+		asset.Precision = len(amount) - dot - 1
+		amount = amount[:dot] + amount[dot+1:]
+	}
+	value, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return asset, errors.New("Invalid asset: " + str)
+	}
+	if negative {
+		value = -value
+	}
+	asset.Amount = value
+	return asset, nil
+}
+
+//Float returns amount of asset in whole units
+func (a Asset) Float() float64 {
+	return float64(a.Amount) / math.Pow10(a.Precision)
+}
+
+//Rat returns exact amount of asset in whole units
+func (a Asset) Rat() *big.Rat {
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(a.Precision)), nil)
+	return new(big.Rat).SetFrac(big.NewInt(a.Amount), denom)
+}
+
+//String formats asset the same way as nodeos does
+func (a Asset) String() string {
+	amount := a.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := strconv.FormatInt(amount, 10)
+	if a.Precision == 0 {
+		return sign + digits + " " + a.Symbol
+	}
+	for len(digits) <= a.Precision {
+		digits = "0" + digits
+	}
+	point := len(digits) - a.Precision
+	return sign + digits[:point] + "." + digits[point:] + " " + a.Symbol
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+
+func TestParseAsset(t *testing.T) {
+	tests := []struct {
+		str       string
+		expected  Asset
+		formatted string
+	}{
+		{ "1.0500 EOS", Asset{ Amount: 10500, Precision: 4, Symbol: "EOS" }, "1.0500 EOS" },
+		{ "0.0001 EOS", Asset{ Amount: 1, Precision: 4, Symbol: "EOS" }, "0.0001 EOS" },
+		{ "-1.0500 EOS", Asset{ Amount: -10500, Precision: 4, Symbol: "EOS" }, "-1.0500 EOS" },
+		{ "-0.0001 EOS", Asset{ Amount: -1, Precision: 4, Symbol: "EOS" }, "-0.0001 EOS" },
+		{ "10 SYS", Asset{ Amount: 10, Precision: 0, Symbol: "SYS" }, "10 SYS" },
+		{ "-10 SYS", Asset{ Amount: -10, Precision: 0, Symbol: "SYS" }, "-10 SYS" },
+		{ "0 SYS", Asset{ Amount: 0, Precision: 0, Symbol: "SYS" }, "0 SYS" },
+		{ "007.50 EOS", Asset{ Amount: 750, Precision: 2, Symbol: "EOS" }, "7.50 EOS" },
+		{ "0000.0000 EOS", Asset{ Amount: 0, Precision: 4, Symbol: "EOS" }, "0.0000 EOS" },
+		{ "  2.5   TKN ", Asset{ Amount: 25, Precision: 1, Symbol: "TKN" }, "2.5 TKN" },
+	}
+	for _, test := range tests {
+		asset, err := parseAsset(test.str)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.str, err)
+			continue
+		}
+		if asset != test.expected {
+			t.Errorf("%q: expected %+v, got %+v", test.str, test.expected, asset)
+		}
+		if asset.String() != test.formatted {
+			t.Errorf("%q: expected %q, got %q", test.str, test.formatted, asset.String())
+		}
+	}
+}
+
+func TestParseAssetErrors(t *testing.T) {
+	tests := []string {
+		"",
+		"EOS",
+		"1.0000",
+		"1.0000 EOS extra",
+		"abc EOS",
+		"--1.0000 EOS",
+		"+1.0000 EOS",
+		"1. EOS",
+		".5 EOS",
+		"1.2.3 EOS",
+		"1e3 EOS",
+		"1,5 EOS",
+		"99999999999999999999 EOS",
+	}
+	for _, test := range tests {
+		if _, err := parseAsset(test); err == nil {
+			t.Errorf("%q: expected error", test)
+		}
+	}
+}
+
+func TestAssetString(t *testing.T) {
+	tests := []struct {
+		asset    Asset
+		expected string
+	}{
+		{ Asset{ Amount: 10500, Precision: 4, Symbol: "EOS" }, "1.0500 EOS" },
+		{ Asset{ Amount: 5, Precision: 4, Symbol: "EOS" }, "0.0005 EOS" },
+		{ Asset{ Amount: 10000, Precision: 4, Symbol: "EOS" }, "1.0000 EOS" },
+		{ Asset{ Amount: -5, Precision: 4, Symbol: "EOS" }, "-0.0005 EOS" },
+		{ Asset{ Amount: -10500, Precision: 4, Symbol: "EOS" }, "-1.0500 EOS" },
+		{ Asset{ Amount: 0, Precision: 4, Symbol: "EOS" }, "0.0000 EOS" },
+		{ Asset{ Amount: 42, Precision: 0, Symbol: "SYS" }, "42 SYS" },
+		{ Asset{ Amount: -42, Precision: 0, Symbol: "SYS" }, "-42 SYS" },
+		{ Asset{ Amount: 9223372036854775807, Precision: 4, Symbol: "MAX" }, "922337203685477.5807 MAX" },
+	}
+	for _, test := range tests {
+		if result := test.asset.String(); result != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.asset, test.expected, result)
+		}
+	}
+}
+
+func TestAssetRat(t *testing.T) {
+	tests := []struct {
+		asset    Asset
+		expected string
+	}{
+		{ Asset{ Amount: 10000, Precision: 4, Symbol: "EOS" }, "1" },
+		{ Asset{ Amount: 1, Precision: 4, Symbol: "EOS" }, "1/10000" },
+		{ Asset{ Amount: -15000, Precision: 4, Symbol: "EOS" }, "-3/2" },
+		{ Asset{ Amount: 10, Precision: 0, Symbol: "SYS" }, "10" },
+		{ Asset{ Amount: 750, Precision: 2, Symbol: "EOS" }, "15/2" },
+		{ Asset{ Amount: 9223372036854775807, Precision: 18, Symbol: "MAX" }, "9223372036854775807/1000000000000000000" },
+		{ Asset{ Amount: 1, Precision: 20, Symbol: "TINY" }, "1/100000000000000000000" },
+	}
+	for _, test := range tests {
+		expected, _ := new(big.Rat).SetString(test.expected)
+		if result := test.asset.Rat(); result.Cmp(expected) != 0 {
+			t.Errorf("%+v: expected %s, got %s", test.asset, expected.RatString(), result.RatString())
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultBalanceHistoryCacheSize     int = 1000
+const BalanceHistoryRefreshSeconds     int64 = 10
+const BalanceHistoryBatchSize            int = 1000
+const DefaultBalanceHistoryLimit       int64 = 1000
+const MaxBalanceHistoryLimit           int64 = 10000
+const MaxBalanceHistoryPoints            int = 10000
+const BalanceHistoryRescanWindow      uint64 = 100000
+const BalanceHistoryWaitSeconds        int64 = 10
+
+
+//BalancePoint is balance of account after one token action
+type BalancePoint struct {
+	BlockNum                 uint64 `json:"block_num"`
+	BlockTime       json.RawMessage `json:"block_time"`
+	GlobalActionSeq          uint64 `json:"global_action_seq"`
+	TrxId                    string `json:"trx_id"`
+	Action                   string `json:"action"`
+	Change                   string `json:"change"`
+	Balance                  string `json:"balance"`
+	BalanceAmount          *big.Rat `json:"-"`
+}
+
+//balanceHistoryEntry holds up to MaxBalanceHistoryPoints latest balance points
+//of one account, contract and symbol in ascending order
+//BaseBalance is the balance before the first kept point, BaseSeq is the sequence of the last dropped point
+type balanceHistoryEntry struct {
+	Points      []BalancePoint
+	Dropped     bool
+	BaseBalance *big.Rat
+	BaseSeq     uint64
+	Balance     *big.Rat
+	Precision   int
+	MaxSeq      uint64
+	CheckedAt   time.Time
+}
+
+//balanceReplay is the first replay of an entry running in background
+type balanceReplay struct {
+	Done chan struct{}
+	Err  error
+}
+
+//BalanceHistory reconstructs token balances by replaying transfer, issue and retire actions
+//points are cached per account, contract and symbol and refreshed incrementally: only actions
+//after the last replayed one minus BalanceHistoryRescanWindow are requested
+type BalanceHistory struct {
+	Search     ActionsSearch
+	GetIndices func() map[string][]string
+	Entries    map[string]*balanceHistoryEntry
+	Replays    map[string]*balanceReplay
+	MaxSize    int
+	Mutex      sync.Mutex
+}
+
+var errBalanceHistoryReplaying = errors.New("Balance history is being replayed, try again later")
+
+
+func newBalanceHistory(client *elastic.Client, getIndices func() map[string][]string, maxSize int) *BalanceHistory {
+	if maxSize <= 0 {
+		maxSize = DefaultBalanceHistoryCacheSize
+	}
+	history := new(BalanceHistory)
+	history.Search = newActionsSearch(client)
+	history.GetIndices = getIndices
+	history.Entries = make(map[string]*balanceHistoryEntry)
+	history.Replays = make(map[string]*balanceReplay)
+	history.MaxSize = maxSize
+	return history
+}
+
+//points returns kept balance points of account in ascending order
+//the first replay of the full history runs in background,
+//errBalanceHistoryReplaying is returned if it takes longer than BalanceHistoryWaitSeconds
+func (h *BalanceHistory) points(account string, contract string, symbol string) (*balanceHistoryEntry, error) {
+	key := account + ":" + contract + ":" + symbol
+	h.Mutex.Lock()
+	entry, ok := h.Entries[key]
+	if ok && time.Since(entry.CheckedAt) < time.Duration(BalanceHistoryRefreshSeconds) * time.Second {
+		h.Mutex.Unlock()
+		return entry, nil
+	}
+	if !ok {
+		replay, running := h.Replays[key]
+		if !running {
+			replay = &balanceReplay{ Done: make(chan struct{}) }
+			h.Replays[key] = replay
+			go func () {
+				_, replay.Err = h.refresh(key, account, contract, symbol, nil)
+				h.Mutex.Lock()
+				delete(h.Replays, key)
+				h.Mutex.Unlock()
+				close(replay.Done)
+			}()
+		}
+		h.Mutex.Unlock()
+		select {
+		case <-replay.Done:
+		case <-time.After(time.Duration(BalanceHistoryWaitSeconds) * time.Second):
+			return nil, errBalanceHistoryReplaying
+		}
+		if replay.Err != nil {
+			return nil, replay.Err
+		}
+		h.Mutex.Lock()
+		entry, ok = h.Entries[key]
+		h.Mutex.Unlock()
+		if !ok {
+			//evicted right after the replay
+			return nil, errBalanceHistoryReplaying
+		}
+		return entry, nil
+	}
+	h.Mutex.Unlock()
+
+	updated, err := h.refresh(key, account, contract, symbol, entry)
+	if err != nil {
+		//keep using outdated points
+		return entry, nil
+	}
+	return updated, nil
+}
+
+//refresh replays new actions of entry (all actions if entry is nil) and stores the result
+func (h *BalanceHistory) refresh(key string, account string, contract string, symbol string, entry *balanceHistoryEntry) (*balanceHistoryEntry, error) {
+	if entry == nil {
+		entry = &balanceHistoryEntry { Points: []BalancePoint{}, BaseBalance: new(big.Rat), Balance: new(big.Rat) }
+	}
+	updated, err := replayBalanceActions(h.Search, h.GetIndices()[ActionTracesIndexPrefix], account, contract, symbol, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+	if cached, ok := h.Entries[key]; ok && cached.MaxSeq > updated.MaxSeq {
+		//refreshed concurrently
+		return cached, nil
+	}
+	if _, ok := h.Entries[key]; !ok && len(h.Entries) >= h.MaxSize {
+		//evict an arbitrary entry
+		for k, _ := range h.Entries {
+			delete(h.Entries, k)
+			break
+		}
+	}
+	h.Entries[key] = updated
+	return updated, nil
+}
+
+
+//replayBalanceActions applies token actions after entry.MaxSeq to the balance of entry
+//and returns a new entry, entry itself may be shared with readers and is not modified
+//actions may be indexed out of order, so points of the last BalanceHistoryRescanWindow sequences are replayed again
+func replayBalanceActions(search ActionsSearch, indices []string, account string, contract string, symbol string, entry *balanceHistoryEntry) (*balanceHistoryEntry, error) {
+	//transfers are matched by the notification of the account,
+	//issue and retire change balance of the issuer which authorizes them
+	transferQuery := elastic.NewBoolQuery().
+		Filter(elastic.NewMatchQuery("act.name", "transfer")).
+		Filter(elastic.NewMatchQuery("receipt.receiver", account))
+	issuerQuery := elastic.NewBoolQuery().
+		Filter(elastic.NewBoolQuery().MinimumNumberShouldMatch(1).
+			Should(elastic.NewMatchQuery("act.name", "issue"), elastic.NewMatchQuery("act.name", "retire"))).
+		Filter(elastic.NewMatchQuery("receipt.receiver", contract)).
+		Filter(elastic.NewMatchQuery("act.authorization.actor", account))
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewMatchQuery("act.account", contract)).
+		Filter(elastic.NewMatchQuery("act.data.quantity", symbol)).
+		Filter(elastic.NewBoolQuery().MinimumNumberShouldMatch(1).Should(transferQuery, issuerQuery))
+
+	afterSeq := entry.BaseSeq
+	if entry.MaxSeq > afterSeq + BalanceHistoryRescanWindow {
+		afterSeq = entry.MaxSeq - BalanceHistoryRescanWindow
+	}
+	kept := sort.Search(len(entry.Points), func(i int) bool {
+		return entry.Points[i].GlobalActionSeq > afterSeq
+	})
+	result := &balanceHistoryEntry { Dropped: entry.Dropped, BaseBalance: entry.BaseBalance, BaseSeq: entry.BaseSeq,
+		Balance: new(big.Rat).Set(entry.BaseBalance), Precision: entry.Precision, MaxSeq: entry.MaxSeq, CheckedAt: time.Now() }
+	if kept > 0 {
+		result.Balance.Set(entry.Points[kept - 1].BalanceAmount)
+	}
+	newPoints := make([]BalancePoint, 0)
+	for {
+		page, err := search(indices, query, afterSeq, BalanceHistoryBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		afterSeq = page.LastSeq
+		for _, action := range page.Actions {
+			change, precision, name, ok := balanceChange(action.Trace, account, contract, symbol)
+			if !ok {
+				continue
+			}
+			result.Balance.Add(result.Balance, change)
+			result.Precision = precision
+			blockNum, _ := parseUint64(action.Trace.BlockNum)
+			newPoints = append(newPoints, BalancePoint { BlockNum: blockNum, BlockTime: action.Trace.BlockTime,
+				GlobalActionSeq: action.Seq, TrxId: action.Trace.TrxId, Action: name,
+				Change: formatAmount(change, precision, symbol), Balance: formatAmount(result.Balance, precision, symbol),
+				BalanceAmount: new(big.Rat).Set(result.Balance) })
+		}
+		if page.Hits < BalanceHistoryBatchSize {
+			break
+		}
+	}
+	if afterSeq > result.MaxSeq {
+		result.MaxSeq = afterSeq
+	}
+	//points slice may be shared with readers, never append to it in place
+	points := append(append(make([]BalancePoint, 0, kept + len(newPoints)), entry.Points[:kept]...), newPoints...)
+	if dropped := len(points) - MaxBalanceHistoryPoints; dropped > 0 {
+		result.Dropped = true
+		result.BaseBalance = points[dropped - 1].BalanceAmount
+		result.BaseSeq = points[dropped - 1].GlobalActionSeq
+		points = points[dropped:]
+	}
+	result.Points = points
+	return result, nil
+}
+
+//balanceChange returns change of account balance made by token action
+//ok is false if action doesn't change the balance of the symbol
+func balanceChange(trace *ActionTrace, account string, contract string, symbol string) (*big.Rat, int, string, bool) {
+	var actionContract, name string
+	json.Unmarshal(trace.Act.Account, &actionContract)
+	json.Unmarshal(trace.Act.Name, &name)
+	if actionContract != contract {
+		return nil, 0, "", false
+	}
+	var data transferData
+	if json.Unmarshal(trace.Act.Data, &data) != nil {
+		return nil, 0, "", false
+	}
+	asset, err := parseAsset(data.Quantity)
+	if err != nil || asset.Symbol != symbol {
+		return nil, 0, "", false
+	}
+	amount, precision := asset.Rat(), asset.Precision
+	switch name {
+	case "transfer":
+		if data.To == account && data.From != account {
+			return amount, precision, name, true
+		}
+		if data.From == account && data.To != account {
+			return amount.Neg(amount), precision, name, true
+		}
+	case "issue":
+		return amount, precision, name, true
+	case "retire":
+		return amount.Neg(amount), precision, name, true
+	}
+	return nil, 0, "", false
+}
+
+func formatAmount(amount *big.Rat, precision int, symbol string) string {
+	return amount.FloatString(precision) + " " + symbol
+}
+
+
+//getBalanceHistory returns balance of account at given block (the latest one if block is not set)
+//and the latest balance points up to this block
+func getBalanceHistory(history *BalanceHistory, params GetBalanceHistoryParams) (*GetBalanceHistoryResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 || len(params.Contract) == 0 || len(params.Symbol) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name, contract and symbol are required"))
+	}
+	limit := DefaultBalanceHistoryLimit
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if limit < 0 || limit > MaxBalanceHistoryLimit {
+		return nil, newErrorWithCode(400, errors.New("limit must be between 0 and " + strconv.FormatInt(MaxBalanceHistoryLimit, 10)))
+	}
+	blockNum := uint64(math.MaxUint64)
+	if params.BlockNum != nil {
+		blockNum = *params.BlockNum
+	}
+	entry, err := history.points(params.AccountName, params.Contract, params.Symbol)
+	if err == errBalanceHistoryReplaying {
+		return nil, newErrorWithCode(503, err)
+	}
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	//number of points made up to the block
+	count := sort.Search(len(entry.Points), func(i int) bool {
+		return entry.Points[i].BlockNum > blockNum
+	})
+	if count == 0 && entry.Dropped {
+		return nil, newErrorWithCode(400, errors.New("block_num is older than kept balance history, only the latest " +
+			strconv.Itoa(MaxBalanceHistoryPoints) + " points are kept"))
+	}
+	result := new(GetBalanceHistoryResult)
+	result.AccountName = params.AccountName
+	result.Contract = params.Contract
+	result.Symbol = params.Symbol
+	result.Balance = formatAmount(entry.BaseBalance, entry.Precision, params.Symbol)
+	if count > 0 {
+		result.Balance = entry.Points[count - 1].Balance
+		result.BlockNum = entry.Points[count - 1].BlockNum
+	}
+	start := count - int(limit)
+	if start < 0 {
+		start = 0
+	}
+	result.History = entry.Points[start:count]
+	result.Truncated = start > 0 || entry.Dropped
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+
+//testTokenAction returns trace of token action of eosio.token
+func testTokenAction(t *testing.T, seq uint64, name string, from string, to string, quantity string) TailedAction {
+	return testTrace(t, seq, seq, fmt.Sprintf(`{ "account": "eosio.token", "name": "%s",
+		"authorization": [ { "actor": "%s", "permission": "active" } ],
+		"data": { "from": "%s", "to": "%s", "quantity": "%s", "memo": "" } }`, name, from, from, to, quantity))
+}
+
+func newTestBalanceEntry() *balanceHistoryEntry {
+	return &balanceHistoryEntry { Points: []BalancePoint{}, BaseBalance: new(big.Rat), Balance: new(big.Rat) }
+}
+
+
+func TestBalanceChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   TailedAction
+		change   string
+		ok       bool
+	}{
+		{ "incoming transfer", testTokenAction(t, 1, "transfer", "bob", "alice", "1.5000 EOS"), "3/2", true },
+		{ "outgoing transfer", testTokenAction(t, 1, "transfer", "alice", "bob", "1.5000 EOS"), "-3/2", true },
+		{ "transfer to self", testTokenAction(t, 1, "transfer", "alice", "alice", "1.5000 EOS"), "", false },
+		{ "transfer of others", testTokenAction(t, 1, "transfer", "bob", "carol", "1.5000 EOS"), "", false },
+		{ "another symbol", testTokenAction(t, 1, "transfer", "bob", "alice", "1.5000 EOSDT"), "", false },
+		{ "invalid quantity", testTokenAction(t, 1, "transfer", "bob", "alice", "1.5 EOS X"), "", false },
+		{ "issue", testTokenAction(t, 1, "issue", "alice", "alice", "10.0000 EOS"), "10", true },
+		{ "retire", testTokenAction(t, 1, "retire", "alice", "", "2.0000 EOS"), "-2", true },
+		{ "other action", testTokenAction(t, 1, "open", "alice", "alice", "0.0000 EOS"), "", false },
+		{ "another contract", testTrace(t, 1, 1, `{ "account": "fake.token", "name": "transfer",
+			"data": { "from": "bob", "to": "alice", "quantity": "1.5000 EOS", "memo": "" } }`), "", false },
+	}
+	for _, test := range tests {
+		change, precision, _, ok := balanceChange(test.action.Trace, "alice", "eosio.token", "EOS")
+		if ok != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.name, test.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		expected, _ := new(big.Rat).SetString(test.change)
+		if change.Cmp(expected) != 0 || precision != 4 {
+			t.Errorf("%s: expected %s with precision 4, got %s with precision %d", test.name,
+				expected.RatString(), change.RatString(), precision)
+		}
+	}
+}
+
+func TestReplayBalanceActions(t *testing.T) {
+	actions := []TailedAction {
+		testTokenAction(t, 10, "transfer", "bob", "alice", "10.0000 EOS"),
+		testTokenAction(t, 20, "transfer", "alice", "bob", "2.5000 EOS"),
+		//other symbol found by the analyzed match query
+		testTokenAction(t, 30, "transfer", "bob", "alice", "1.0000 EOSDT"),
+		{ Seq: 40 },
+		testTokenAction(t, 50, "retire", "alice", "", "0.5000 EOS"),
+	}
+	entry := newTestBalanceEntry()
+	result, err := replayBalanceActions(testActionsSearch(actions), nil, "alice", "eosio.token", "EOS", entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	type point struct {
+		seq     uint64
+		change  string
+		balance string
+	}
+	checkPoints := func(points []BalancePoint, expected []point) {
+		if len(points) != len(expected) {
+			t.Fatalf("expected %d points, got %+v", len(expected), points)
+		}
+		for i, p := range points {
+			if p.GlobalActionSeq != expected[i].seq || p.Change != expected[i].change || p.Balance != expected[i].balance {
+				t.Errorf("point %d: expected %+v, got %+v", i, expected[i], p)
+			}
+		}
+	}
+	checkPoints(result.Points, []point {
+		{ 10, "10.0000 EOS", "10.0000 EOS" },
+		{ 20, "-2.5000 EOS", "7.5000 EOS" },
+		{ 50, "-0.5000 EOS", "7.0000 EOS" },
+	})
+	if result.MaxSeq != 50 || formatAmount(result.Balance, result.Precision, "EOS") != "7.0000 EOS" {
+		t.Errorf("expected balance 7.0000 EOS at 50, got %s at %d",
+			formatAmount(result.Balance, result.Precision, "EOS"), result.MaxSeq)
+	}
+	if len(entry.Points) != 0 || entry.Balance.Sign() != 0 || entry.MaxSeq != 0 {
+		t.Errorf("replay modified the cached entry: %+v", entry)
+	}
+
+	//action indexed out of order within the rescan window is replayed in sequence order
+	late := testTokenAction(t, 35, "transfer", "bob", "alice", "1.0000 EOS")
+	actions = append(actions[:4:4], late, actions[4], testTokenAction(t, 60, "transfer", "alice", "bob", "3.0000 EOS"))
+	refreshed, err := replayBalanceActions(testActionsSearch(actions), nil, "alice", "eosio.token", "EOS", result)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkPoints(refreshed.Points, []point {
+		{ 10, "10.0000 EOS", "10.0000 EOS" },
+		{ 20, "-2.5000 EOS", "7.5000 EOS" },
+		{ 35, "1.0000 EOS", "8.5000 EOS" },
+		{ 50, "-0.5000 EOS", "8.0000 EOS" },
+		{ 60, "-3.0000 EOS", "5.0000 EOS" },
+	})
+	if refreshed.MaxSeq != 60 {
+		t.Errorf("expected max seq 60, got %d", refreshed.MaxSeq)
+	}
+	if len(result.Points) != 3 {
+		t.Errorf("refresh modified the previous entry: %+v", result.Points)
+	}
+}
+
+func TestReplayBalanceActionsDropsPoints(t *testing.T) {
+	actions := make([]TailedAction, 0, MaxBalanceHistoryPoints + 2)
+	for i := 1; i <= MaxBalanceHistoryPoints + 2; i++ {
+		actions = append(actions, testTokenAction(t, uint64(i), "transfer", "bob", "alice", "1.0000 EOS"))
+	}
+	result, err := replayBalanceActions(testActionsSearch(actions), nil, "alice", "eosio.token", "EOS", newTestBalanceEntry())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Points) != MaxBalanceHistoryPoints || !result.Dropped {
+		t.Fatalf("expected %d points with dropped flag, got %d", MaxBalanceHistoryPoints, len(result.Points))
+	}
+	if result.BaseSeq != 2 || result.BaseBalance.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Errorf("expected base balance 2 at 2, got %s at %d", result.BaseBalance.RatString(), result.BaseSeq)
+	}
+	if result.Points[0].GlobalActionSeq != 3 || result.Points[0].Balance != "3.0000 EOS" {
+		t.Errorf("expected the first kept point at 3 with 3.0000 EOS, got %+v", result.Points[0])
+	}
+}
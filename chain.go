@@ -6,15 +6,203 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
 
+//used when config doesn't contain any node urls
 const RemoteNode                   string = "http://eosbp-0.atticlab.net"
+const DefaultNodeHealthCheckIntervalSeconds int64 = 10
+const DefaultNodeMaxBlockLag              uint64 = 60
+const NodeRequestTimeoutSeconds            int64 = 5
+const DefaultChainInfoPollIntervalMs       int64 = 500
+const DefaultChainInfoMaxAgeMs             int64 = 3000
 
 
-//returns info from node chain api
-func getInfo() (*ChainGetInfoResult, error) {
-	resp, err := http.Get(RemoteNode + "/v1/chain/get_info")
+//Node holds state of a single nodeos endpoint
+type Node struct {
+	Url          string
+	Healthy      bool
+	HeadBlockNum uint64
+	LastError    error
+	LastCheck    time.Time
+}
+
+//NodePool keeps a list of nodeos endpoints
+//and chooses the node to send chain api requests to
+type NodePool struct {
+	Nodes       []*Node
+	MaxBlockLag uint64
+	Client      *http.Client
+	Mutex       sync.RWMutex
+}
+
+//ChainInfoCache keeps the latest get_info result
+//which is refreshed by background poller
+type ChainInfoCache struct {
+	Info      *ChainGetInfoResult
+	UpdatedAt time.Time
+	MaxAge    time.Duration
+	Mutex     sync.RWMutex
+}
+
+
+func newNodePool(urls []string, maxBlockLag uint64) *NodePool {
+	if len(urls) == 0 {
+		urls = []string{ RemoteNode }
+	}
+	if maxBlockLag == 0 {
+		maxBlockLag = DefaultNodeMaxBlockLag
+	}
+	pool := new(NodePool)
+	pool.MaxBlockLag = maxBlockLag
+	pool.Client = &http.Client{ Timeout: time.Duration(NodeRequestTimeoutSeconds) * time.Second }
+	for _, url := range urls {
+		//nodes are considered healthy until the first check says otherwise
+		pool.Nodes = append(pool.Nodes, &Node{ Url: url, Healthy: true })
+	}
+	return pool
+}
+
+//startHealthChecks runs checkNodes every intervalSeconds in background
+func (p *NodePool) startHealthChecks(intervalSeconds int64) {
+	if intervalSeconds <= 0 {
+		intervalSeconds = DefaultNodeHealthCheckIntervalSeconds
+	}
+	go func () {
+		for {
+			p.checkNodes()
+			time.Sleep(time.Duration(intervalSeconds) * time.Second)
+		}
+	}()
+}
+
+//checkNodes requests get_info from every node,
+//marks unreachable nodes and nodes which head block
+//is more than MaxBlockLag blocks behind the freshest node as unhealthy
+func (p *NodePool) checkNodes() {
+	heads := make([]uint64, len(p.Nodes))
+	errs := make([]error, len(p.Nodes))
+	var wg sync.WaitGroup
+	for i, node := range p.Nodes {
+		wg.Add(1)
+		go func (i int, url string) {
+			defer wg.Done()
+			info, err := p.getInfoFromNode(url)
+			if err == nil {
+				heads[i], err = parseUint64(info.HeadBlockNum)
+			}
+			errs[i] = err
+		}(i, node.Url)
+	}
+	wg.Wait()
+
+	best := uint64(0)
+	for i, head := range heads {
+		if errs[i] == nil && head > best {
+			best = head
+		}
+	}
+
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	healthyCount := 0
+	for i, node := range p.Nodes {
+		wasHealthy := node.Healthy
+		node.LastCheck = time.Now()
+		node.LastError = errs[i]
+		if errs[i] == nil {
+			node.HeadBlockNum = heads[i]
+			if best - heads[i] > p.MaxBlockLag {
+				node.LastError = fmt.Errorf("head block %d is %d blocks behind %d", heads[i], best - heads[i], best)
+			}
+		}
+		node.Healthy = node.LastError == nil
+		if node.Healthy {
+			healthyCount++
+		}
+		if wasHealthy && !node.Healthy {
+			log.Printf("Node %s marked unhealthy: %v\n", node.Url, node.LastError)
+		} else if !wasHealthy && node.Healthy {
+			log.Printf("Node %s is healthy again\n", node.Url)
+		}
+	}
+	if healthyCount == 0 {
+		log.Printf("ALARM: none of %d nodes is healthy\n", len(p.Nodes))
+	}
+}
+
+//orderedNodes returns urls of healthy nodes sorted by head block (freshest first)
+//followed by unhealthy nodes which are used only as the last resort
+func (p *NodePool) orderedNodes() []string {
+	p.Mutex.RLock()
+	defer p.Mutex.RUnlock()
+	healthy := make([]*Node, 0, len(p.Nodes))
+	unhealthy := make([]*Node, 0)
+	for _, node := range p.Nodes {
+		if node.Healthy {
+			healthy = append(healthy, node)
+		} else {
+			unhealthy = append(unhealthy, node)
+		}
+	}
+	sort.SliceStable(healthy, func(i, j int) bool {
+		return healthy[i].HeadBlockNum > healthy[j].HeadBlockNum
+	})
+	urls := make([]string, 0, len(p.Nodes))
+	for _, node := range append(healthy, unhealthy...) {
+		urls = append(urls, node.Url)
+	}
+	return urls
+}
+
+func (p *NodePool) markFailed(url string, err error) {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	for _, node := range p.Nodes {
+		if node.Url == url && node.Healthy {
+			node.Healthy = false
+			node.LastError = err
+			log.Printf("Node %s marked unhealthy: %v\n", url, err)
+		}
+	}
+}
+
+//post sends request to the best node
+//and retries it on the next node if the request fails
+func (p *NodePool) post(path string, body []byte) ([]byte, error) {
+	var lastErr error = errors.New("No nodes configured")
+	for _, url := range p.orderedNodes() {
+		resp, err := p.Client.Post(url + path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			p.markFailed(url, err)
+			lastErr = err
+			continue
+		}
+		respBytes, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			p.markFailed(url, err)
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			lastErr = fmt.Errorf("%s%s responded with status %d", url, path, resp.StatusCode)
+			p.markFailed(url, lastErr)
+			continue
+		}
+		return respBytes, nil
+	}
+	return nil, lastErr
+}
+
+func (p *NodePool) getInfoFromNode(url string) (*ChainGetInfoResult, error) {
+	resp, err := p.Client.Get(url + "/v1/chain/get_info")
 	if err != nil {
 		return nil, err
 	}
@@ -23,26 +211,94 @@ func getInfo() (*ChainGetInfoResult, error) {
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get_info responded with status %d", resp.StatusCode)
+	}
+	result := new(ChainGetInfoResult)
+	err = json.Unmarshal(bytes, &result)
+	return result, err
+}
+
+
+//returns info from node chain api
+func (p *NodePool) getInfo() (*ChainGetInfoResult, error) {
+	bytes, err := p.post("/v1/chain/get_info", []byte("{}"))
+	if err != nil {
+		return nil, err
+	}
 	result := new(ChainGetInfoResult)
 	err = json.Unmarshal(bytes, &result)
 	return result, err
 }
 
+//startPolling refreshes chain info from nodes every intervalMs in background
+func (c *ChainInfoCache) startPolling(nodes *NodePool, intervalMs int64) {
+	if intervalMs <= 0 {
+		intervalMs = DefaultChainInfoPollIntervalMs
+	}
+	go func () {
+		for {
+			//failures are logged by node pool health checks
+			c.refresh(nodes)
+			time.Sleep(time.Duration(intervalMs) * time.Millisecond)
+		}
+	}()
+}
+
+func (c *ChainInfoCache) refresh(nodes *NodePool) error {
+	info, err := nodes.getInfo()
+	if err != nil {
+		return err
+	}
+	c.Mutex.Lock()
+	c.Info = info
+	c.UpdatedAt = time.Now()
+	c.Mutex.Unlock()
+	return nil
+}
+
+//get returns cached chain info and its age
+//it never calls the node, cached value is refreshed only by the poller
+//value older than MaxAge is not returned
+func (c *ChainInfoCache) get() (*ChainGetInfoResult, time.Duration, error) {
+	c.Mutex.RLock()
+	info := c.Info
+	age := time.Since(c.UpdatedAt)
+	c.Mutex.RUnlock()
+	if info == nil {
+		return nil, 0, errors.New("Chain info is not available yet")
+	}
+	if age > c.MaxAge {
+		return nil, age, fmt.Errorf("Chain info is %v old", age)
+	}
+	return info, age, nil
+}
+
+//returns balances of account in token contract code from node chain api
+func (p *NodePool) getCurrencyBalance(code string, account string) ([]string, error) {
+	body, err := json.Marshal(map[string]string{ "code": code, "account": account })
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := p.post("/v1/chain/get_currency_balance", body)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	err = json.Unmarshal(bytes, &result)
+	return result, err
+}
+
 //takes blockNum and transactionId as arguments
 //retrieves block from node chain api
 //searches requested transaction in retrieved block
 //returns the trx->trx field contents in the correct format
-func getTransactionFromBlock(blockNum json.RawMessage, txId string) (json.RawMessage, error) {
+func (p *NodePool) getTransactionFromBlock(blockNum json.RawMessage, txId string) (json.RawMessage, error) {
 	var result json.RawMessage
 	u := GetBlockParams { BlockNum: blockNum }
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(u)
-	resp, err := http.Post(RemoteNode + "/v1/chain/get_block", "application/json", b)
-	if err != nil {
-		return result, err
-	}
-	bytes, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
+	bytes, err := p.post("/v1/chain/get_block", b.Bytes())
 	if err != nil {
 		return result, err
 	}
@@ -78,4 +334,22 @@ func getTransactionFromBlock(blockNum json.RawMessage, txId string) (json.RawMes
 		}
 	}
 	return result, errors.New("Transaction not found")
-}
\ No newline at end of file
+}
+
+
+//parseUint64 parses json number or json string containing number
+func parseUint64(raw json.RawMessage) (uint64, error) {
+	var tmp interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err := decoder.Decode(&tmp)
+	if err != nil {
+		return 0, err
+	}
+	if n, ok := tmp.(json.Number); ok {
+		return strconv.ParseUint(string(n), 10, 64)
+	} else if s, ok := tmp.(string); ok {
+		return strconv.ParseUint(s, 10, 64)
+	}
+	return 0, errors.New("Value is not a number")
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultControlGraphDepth  int = 3
+const MaxControlGraphDepth      int = 10
+const MaxControlGraphEdges      int = 10000
+const ControlGraphMsearchBatch  int = 100
+
+
+//permissionKey identifies permission of account
+type permissionKey struct {
+	Account    string
+	Permission string
+}
+
+func (k permissionKey) String() string {
+	return k.Account + "@" + k.Permission
+}
+
+//controlPair is an entry of account_controls: Controller is in authority of Key
+type controlPair struct {
+	Controller string
+	Key        permissionKey
+}
+
+
+//searchControlledAccounts returns documents of accounts controlled by any of controllers
+//accounts are requested with one msearch (one request per index) and deduplicated by name
+//truncated is set if some index has more than MaxQuerySize matching accounts
+func searchControlledAccounts(client *elastic.Client, controllers []string, indices map[string][]string) ([]Account, bool, error) {
+	result := make([]Account, 0)
+	if len(controllers) == 0 || len(indices[AccountsIndexPrefix]) == 0 {
+		return result, false, nil
+	}
+	controllersQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, controller := range controllers {
+		controllersQuery = controllersQuery.Should(elastic.NewTermQuery("account_controls.name.keyword", controller))
+	}
+	query := elastic.NewBoolQuery().Filter(controllersQuery)
+	msearch := client.MultiSearch()
+	for _, index := range indices[AccountsIndexPrefix] {
+		msearch.Add(elastic.NewSearchRequest().Index(index).Query(query).Size(MaxQuerySize))
+	}
+	msearchResult, err := msearch.Do(context.Background())
+	if err != nil || msearchResult == nil || msearchResult.Responses == nil {
+		if err == nil {
+			err = errors.New("Empty ES response")
+		}
+		return nil, false, err
+	}
+	truncated := false
+	seen := make(map[string]bool)
+	for _, resp := range msearchResult.Responses {
+		if resp == nil || resp.Error != nil || resp.Hits == nil {
+			continue
+		}
+		if resp.Hits.TotalHits > int64(len(resp.Hits.Hits)) {
+			truncated = true
+		}
+		for _, hit := range resp.Hits.Hits {
+			if hit == nil || hit.Source == nil {
+				continue
+			}
+			var account Account
+			err = json.Unmarshal(*hit.Source, &account)
+			if err != nil {
+				return nil, false, errors.New("Failed to parse ES response")
+			}
+			if seen[account.Name] {
+				continue
+			}
+			seen[account.Name] = true
+			result = append(result, account)
+		}
+	}
+	return result, truncated, nil
+}
+
+//getPermissionAuthorities returns the current authority of every permission
+//authority is taken from the latest updateauth, deleteauth or newaccount action of the permission,
+//deleted permissions and permissions without indexed actions are omitted
+func getPermissionAuthorities(client *elastic.Client, keys []permissionKey, indices map[string][]string) (map[permissionKey]*Authority, error) {
+	result := make(map[permissionKey]*Authority)
+	if len(indices[ActionTracesIndexPrefix]) == 0 {
+		return result, nil
+	}
+	for start := 0; start < len(keys); start += ControlGraphMsearchBatch {
+		end := start + ControlGraphMsearchBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		msearch := client.MultiSearch()
+		for _, key := range keys[start:end] {
+			permissionQuery := elastic.NewBoolQuery().
+				Filter(elastic.NewMatchQuery("act.data.account", key.Account)).
+				Filter(elastic.NewMatchQuery("act.data.permission", key.Permission))
+			accountQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1).
+				Should(permissionQuery).
+				Should(elastic.NewMatchQuery("act.data.name", key.Account))
+			query := newSystemActionQuery("updateauth", "deleteauth", "newaccount").Filter(accountQuery)
+			msearch.Add(elastic.NewSearchRequest().Index(indices[ActionTracesIndexPrefix]...).Query(query).
+				Sort("receipt.global_sequence", false).Size(10))
+		}
+		msearchResult, err := msearch.Do(context.Background())
+		if err != nil || msearchResult == nil || len(msearchResult.Responses) != end - start {
+			if err == nil {
+				err = errors.New("Empty ES response")
+			}
+			return nil, err
+		}
+		for i, resp := range msearchResult.Responses {
+			if resp == nil || resp.Error != nil || resp.Hits == nil {
+				continue
+			}
+			key := keys[start + i]
+			for _, hit := range resp.Hits.Hits {
+				if hit == nil || hit.Source == nil {
+					continue
+				}
+				authority, deleted, ok := parseAuthorityAction(*hit.Source, key)
+				if !ok {
+					//match query found action of another permission
+					continue
+				}
+				if !deleted {
+					result[key] = authority
+				}
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+//parseAuthorityAction extracts authority of permission from updateauth, deleteauth or newaccount action trace
+//ok is false if action doesn't change the permission
+func parseAuthorityAction(source json.RawMessage, key permissionKey) (*Authority, bool, bool) {
+	var actionTrace ActionTrace
+	if json.Unmarshal(source, &actionTrace) != nil {
+		return nil, false, false
+	}
+	var name string
+	json.Unmarshal(actionTrace.Act.Name, &name)
+	switch name {
+	case "updateauth":
+		var data UpdateAuthData
+		if json.Unmarshal(actionTrace.Act.Data, &data) != nil || data.Account != key.Account || data.Permission != key.Permission {
+			return nil, false, false
+		}
+		return &data.Auth, false, true
+	case "deleteauth":
+		var data DeleteAuthData
+		if json.Unmarshal(actionTrace.Act.Data, &data) != nil || data.Account != key.Account || data.Permission != key.Permission {
+			return nil, false, false
+		}
+		return nil, true, true
+	case "newaccount":
+		var data NewAccountData
+		if json.Unmarshal(actionTrace.Act.Data, &data) != nil || data.Name != key.Account {
+			return nil, false, false
+		}
+		if key.Permission == "owner" {
+			return &data.Owner, false, true
+		}
+		if key.Permission == "active" {
+			return &data.Active, false, true
+		}
+		//permission was created later, but its updateauth is not indexed
+		return nil, false, true
+	}
+	return nil, false, false
+}
+
+
+//getControlGraph returns edges from controlling account to accounts which permissions it controls
+//control is followed transitively up to given depth, every level is requested with one msearch
+//for account_controls and one msearch per ControlGraphMsearchBatch permissions for authorities
+func getControlGraph(client *elastic.Client, params GetControlGraphParams, indices map[string][]string) (*GetControlGraphResult, *ErrorWithCode) {
+	if len(params.ControllingAccount) == 0 {
+		return nil, newErrorWithCode(400, errors.New("controlling_account is required"))
+	}
+	depth := DefaultControlGraphDepth
+	if params.Depth != nil {
+		depth = *params.Depth
+	}
+	if depth < 1 || depth > MaxControlGraphDepth {
+		return nil, newErrorWithCode(400, errors.New("depth must be between 1 and " + strconv.Itoa(MaxControlGraphDepth)))
+	}
+	result := new(GetControlGraphResult)
+	result.Edges = make([]ControlEdge, 0)
+	result.Accounts = []string{ params.ControllingAccount }
+	visited := map[string]bool { params.ControllingAccount: true }
+	level := []string{ params.ControllingAccount }
+	for d := 1; d <= depth && len(level) > 0; d++ {
+		nextLevel := make([]string, 0)
+		for start := 0; start < len(level); start += MaxAccountsPerRequest {
+			end := start + MaxAccountsPerRequest
+			if end > len(level) {
+				end = len(level)
+			}
+			controllers := make(map[string]bool)
+			for _, name := range level[start:end] {
+				controllers[name] = true
+			}
+			accounts, truncated, err := searchControlledAccounts(client, level[start:end], indices)
+			if err != nil {
+				return nil, newErrorWithCode(500, err)
+			}
+			result.Truncated = result.Truncated || truncated
+			pairs := make([]controlPair, 0)
+			keys := make([]permissionKey, 0)
+			seenKeys := make(map[permissionKey]bool)
+			for _, account := range accounts {
+				for _, control := range account.AccountControls {
+					var controller, permission string
+					json.Unmarshal(control.Name, &controller)
+					json.Unmarshal(control.Permission, &permission)
+					if !controllers[controller] {
+						continue
+					}
+					key := permissionKey { Account: account.Name, Permission: permission }
+					pairs = append(pairs, controlPair { Controller: controller, Key: key })
+					if !seenKeys[key] {
+						seenKeys[key] = true
+						keys = append(keys, key)
+					}
+				}
+			}
+			authorities, err := getPermissionAuthorities(client, keys, indices)
+			if err != nil {
+				return nil, newErrorWithCode(500, err)
+			}
+			for _, pair := range pairs {
+				edges := newControlEdges(pair, authorities[pair.Key], d)
+				if len(result.Edges) + len(edges) > MaxControlGraphEdges {
+					result.Truncated = true
+					sort.Strings(result.Accounts)
+					return result, nil
+				}
+				result.Edges = append(result.Edges, edges...)
+				if !visited[pair.Key.Account] {
+					//cycle guard, every account is expanded once
+					visited[pair.Key.Account] = true
+					result.Accounts = append(result.Accounts, pair.Key.Account)
+					nextLevel = append(nextLevel, pair.Key.Account)
+				}
+			}
+		}
+		level = nextLevel
+	}
+	sort.Strings(result.Accounts)
+	return result, nil
+}
+
+//newControlEdges creates edges for every permission of controller in authority
+//one edge without controller permission and weight is created if authority is unknown
+func newControlEdges(pair controlPair, authority *Authority, depth int) []ControlEdge {
+	edge := ControlEdge { Controller: pair.Controller, Account: pair.Key.Account,
+		Permission: pair.Key.Permission, To: pair.Key.String(), Depth: depth }
+	edge.From = pair.Controller
+	if authority == nil {
+		return []ControlEdge{ edge }
+	}
+	if threshold, err := parseUint64(authority.Threshold); err == nil {
+		edge.Threshold = &threshold
+	}
+	result := make([]ControlEdge, 0, 1)
+	for _, account := range authority.Accounts {
+		if account.Permission.Actor != pair.Controller {
+			continue
+		}
+		tmp := edge
+		tmp.ControllerPermission = account.Permission.Permission
+		tmp.From = permissionKey { Account: pair.Controller, Permission: account.Permission.Permission }.String()
+		if weight, err := parseUint64(account.Weight); err == nil {
+			tmp.Weight = &weight
+		}
+		result = append(result, tmp)
+	}
+	if len(result) == 0 {
+		//authority is outdated in accounts index
+		result = append(result, edge)
+	}
+	return result
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"errors"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/olivere/elastic"
 	"context"
-	"net/http"
-	"bufio"
 	"regexp"
 	"strings"
 	"math"
@@ -21,38 +23,100 @@ const TransactionTracesIndex string = "transaction_traces"
 const ActionTracesIndex      string = "action_traces"
 
 const MaxQuerySize int = 10000
+const MaxAccountsPerRequest int = 100
+const DefaultCreatedAccountsLimit int64 = 100
+const DefaultAccountTreeDepth int = 3
+const MaxAccountTreeDepth int = 10
+const MaxAccountTreeSize int = 10000
+//AccountsCountPrecision is the number of created accounts up to which their total is exact
+const AccountsCountPrecision int64 = 40000
+const DefaultIndexPattern string = "^{prefix}-(\\d+)$"
 
 
 //get index list from ES and parse indices from it
+//pattern is a regular expression for index names where {prefix} is replaced by the prefix
+//and the first capture group (if any) is a number used to order indices
 //return a map where every prefix from input array is a key
 //and a value is vector of corresponding indices
-func getIndices(esUrl string, prefixes []string) map[string][]string {
+func getIndices(client *elastic.Client, prefixes []string, pattern string) (map[string][]string, error) {
 	result := make(map[string][]string)
-	resp, err := http.Get(esUrl + "/_cat/indices?v&s=index")
+	names, err := client.IndexNames()
 	if err != nil {
-		return result
-	}
-	defer resp.Body.Close()
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Split(bufio.ScanLines)
-
-	var lines []string
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+		return nil, err
 	}
 	for _, prefix := range prefixes {
-		r, err := regexp.Compile("\\s" + prefix + "-(\\d)*\\s")
+		r, err := regexp.Compile(strings.Replace(pattern, "{prefix}", regexp.QuoteMeta(prefix), -1))
 		if err != nil {
-			return result
+			return nil, err
+		}
+		type numberedIndex struct {
+			name   string
+			number int64
+		}
+		matched := make([]numberedIndex, 0)
+		for _, name := range names {
+			match := r.FindStringSubmatch(name)
+			if match == nil {
+				continue
+			}
+			index := numberedIndex{ name: name }
+			if len(match) > 1 {
+				index.number, _ = strconv.ParseInt(match[1], 10, 64)
+			}
+			matched = append(matched, index)
 		}
-		for _, line := range lines {
-			match := r.FindString(line)
-			if len(match) != 0 {
-				result[prefix] = append(result[prefix], strings.TrimSpace(match))
+		sort.Slice(matched, func(i, j int) bool {
+			if matched[i].number != matched[j].number {
+				return matched[i].number < matched[j].number
 			}
+			return matched[i].name < matched[j].name
+		})
+		for _, index := range matched {
+			result[prefix] = append(result[prefix], index.name)
 		}
 	}
-	return result
+	return result, nil
+}
+
+
+//getIndicesInfo requests block range of every index with given prefixes
+//indices without block_num field (or empty ones) are returned without block range
+func getIndicesInfo(client *elastic.Client, indices map[string][]string, prefixes []string) (map[string]IndexInfo, error) {
+	result := make(map[string]IndexInfo)
+	names := make([]string, 0)
+	msearch := client.MultiSearch()
+	for _, prefix := range prefixes {
+		for _, index := range indices[prefix] {
+			names = append(names, index)
+			msearch.Add(elastic.NewSearchRequest().Index(index).Size(0).
+				Aggregation("min_block_num", elastic.NewMinAggregation().Field("block_num")).
+				Aggregation("max_block_num", elastic.NewMaxAggregation().Field("block_num")))
+		}
+	}
+	if len(names) == 0 {
+		return result, nil
+	}
+	msearchResult, err := msearch.Do(context.Background())
+	if err != nil || msearchResult == nil || msearchResult.Responses == nil {
+		return nil, err
+	}
+	for i, resp := range msearchResult.Responses {
+		if i >= len(names) {
+			break
+		}
+		info := IndexInfo{ Name: names[i] }
+		if resp != nil && resp.Error == nil {
+			min, minOk := resp.Aggregations.Min("min_block_num")
+			max, maxOk := resp.Aggregations.Max("max_block_num")
+			if minOk && maxOk && min.Value != nil && max.Value != nil {
+				info.HasBlockRange = true
+				info.MinBlockNum = uint64(*min.Value)
+				info.MaxBlockNum = uint64(*max.Value)
+			}
+		}
+		result[names[i]] = info
+	}
+	return result, nil
 }
 
 
@@ -118,13 +182,63 @@ func findActionTrace(txTrace *TransactionTrace, actionSeq json.RawMessage) (*Tra
 	return nil, errors.New("Action trace not found in transaction trace")
 }
 
-func getActionTrace(client *elastic.Client, txId string, actionSeq json.RawMessage, indices map[string][]string) (json.RawMessage, error) {
+//routeIndices returns indices which block range contains blockNum
+//the newest index is treated as open ended since it is still being written
+//if index metadata is missing or no index matches, all indices are returned
+func routeIndices(indices []string, indicesInfo map[string]IndexInfo, blockNum uint64) []string {
+	if blockNum == 0 || len(indices) == 0 {
+		return indices
+	}
+	result := make([]string, 0, 1)
+	for i, index := range indices {
+		info, ok := indicesInfo[index]
+		if !ok {
+			return indices
+		}
+		if !info.HasBlockRange {
+			continue
+		}
+		isNewest := i == len(indices) - 1
+		if blockNum >= info.MinBlockNum && (blockNum <= info.MaxBlockNum || isNewest) {
+			result = append(result, index)
+		}
+	}
+	if len(result) == 0 {
+		return indices
+	}
+	return result
+}
+
+
+//indicesFromBlock returns indices which may contain blocks with number blockNum or greater
+//indices without metadata are always included
+func indicesFromBlock(indices []string, indicesInfo map[string]IndexInfo, blockNum uint64) []string {
+	result := make([]string, 0, len(indices))
+	for i, index := range indices {
+		info, ok := indicesInfo[index]
+		isNewest := i == len(indices) - 1
+		if !ok || !info.HasBlockRange || isNewest || info.MaxBlockNum >= blockNum {
+			result = append(result, index)
+		}
+	}
+	return result
+}
+
+//multiGetById requests document with given id from every index in the list
+//returns the found document or nil if document is not found
+func multiGetById(client *elastic.Client, indices []string, id string) (*elastic.GetResult, error) {
+	if len(indices) == 0 {
+		return nil, nil
+	}
 	multiGet := client.MultiGet()
-	for _, index := range indices[TransactionTracesIndexPrefix] {
-		multiGet.Add(elastic.NewMultiGetItem().Index(index).Id(txId))
+	for _, index := range indices {
+		multiGet.Add(elastic.NewMultiGetItem().Index(index).Id(id))
 	}
 	mgetResult, err := multiGet.Do(context.Background())
 	if err != nil || mgetResult == nil || mgetResult.Docs == nil {
+		if err == nil {
+			err = errors.New("Empty ES response")
+		}
 		return nil, err
 	}
 	var getResult *elastic.GetResult
@@ -134,16 +248,106 @@ func getActionTrace(client *elastic.Client, txId string, actionSeq json.RawMessa
 		}
 		getResult = doc
 	}
+	return getResult, nil
+}
+
 
-	if getResult == nil || !getResult.Found || getResult.Source == nil {
-		return nil, errors.New("Action trace not found")
+//searchActionTraces searches actions matching query in all given indices at once
+//returns nil result if there are no indices to search
+func searchActionTraces(client *elastic.Client, indices []string, query elastic.Query, from int, size int, ascOrder bool) (*elastic.SearchResult, error) {
+	if len(indices) == 0 {
+		return nil, nil
 	}
-	var txTrace TransactionTrace
-	err = json.Unmarshal(*getResult.Source, &txTrace)
+	if from + size > MaxQuerySize {
+		return nil, errors.New("Requested page is too deep, use narrower filters")
+	}
+	return client.Search(indices...).
+		Query(query).
+		Sort("receipt.global_sequence", ascOrder).
+		From(from).Size(size).
+		Do(context.Background())
+}
+
+
+//multiGetByIds requests documents with given ids from indices in one multi-get
+//blockNums contains block number for every id and is used to route requests to indices
+//returns a map from document id to the found document, missing documents are omitted
+func multiGetByIds(client *elastic.Client, blockNums map[string]uint64, indices []string, indicesInfo map[string]IndexInfo) (map[string]*elastic.GetResult, error) {
+	result := make(map[string]*elastic.GetResult)
+	mget := func(route bool) error {
+		multiGet := client.MultiGet()
+		items := 0
+		for id, blockNum := range blockNums {
+			if result[id] != nil {
+				continue
+			}
+			targetIndices := indices
+			if route {
+				targetIndices = routeIndices(targetIndices, indicesInfo, blockNum)
+			}
+			for _, index := range targetIndices {
+				multiGet.Add(elastic.NewMultiGetItem().Index(index).Id(id))
+				items++
+			}
+		}
+		if items == 0 {
+			return nil
+		}
+		mgetResult, err := multiGet.Do(context.Background())
+		if err != nil || mgetResult == nil || mgetResult.Docs == nil {
+			if err == nil {
+				err = errors.New("Empty ES response")
+			}
+			return err
+		}
+		for _, doc := range mgetResult.Docs {
+			if doc == nil || doc.Error != nil || !doc.Found || doc.Source == nil {
+				continue
+			}
+			result[doc.Id] = doc
+		}
+		return nil
+	}
+	err := mget(true)
 	if err != nil {
-		return nil, errors.New("Failed to parse ES response")
+		return nil, err
+	}
+	if len(result) < len(blockNums) {
+		//block range of indices might be outdated, search missing documents in all indices
+		err = mget(false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+
+//getTransactionTraces requests transaction traces with given ids in one multi-get
+//blockNums contains block number for every id and is used to route requests to indices
+//returns a map from transaction id to parsed transaction trace, missing traces are omitted
+func getTransactionTraces(client *elastic.Client, blockNums map[string]uint64, indices map[string][]string, indicesInfo map[string]IndexInfo) (map[string]*TransactionTrace, error) {
+	docs, err := multiGetByIds(client, blockNums, indices[TransactionTracesIndexPrefix], indicesInfo)
+	if err != nil {
+		return nil, err
 	}
-	trace, err := findActionTrace(&txTrace, actionSeq)
+	result := make(map[string]*TransactionTrace)
+	for id, doc := range docs {
+		txTrace := new(TransactionTrace)
+		err = json.Unmarshal(*doc.Source, txTrace)
+		if err != nil {
+			return nil, errors.New("Failed to parse ES response")
+		}
+		result[id] = txTrace
+	}
+	return result, nil
+}
+
+
+//formatActionTrace finds action trace with given global sequence in transaction trace
+//and returns it with abi of setabi actions replaced by bytes
+func formatActionTrace(txTrace *TransactionTrace, actionSeq json.RawMessage) (json.RawMessage, error) {
+	trace, err := findActionTrace(txTrace, actionSeq)
 	if err != nil {
 		return nil, err
 	}
@@ -164,17 +368,87 @@ func getActionTrace(client *elastic.Client, txId string, actionSeq json.RawMessa
 }
 
 
-func countActions(client *elastic.Client, params GetActionsParams, index string) (int64, error) {
+const OrderAsc           string = "asc"
+const OrderDesc          string = "desc"
+const DefaultCursorLimit int64 = 20
+const MaxCursorLimit     int64 = 1000
+
+const MatchAny      string = "any"
+const MatchReceiver string = "receiver"
+const MatchActor    string = "actor"
+
+
+//newActionsQuery returns query that matches actions requested by get_actions params
+func newActionsQuery(params GetActionsParams) *elastic.BoolQuery {
 	query := elastic.NewBoolQuery()
-	query = query.Filter(elastic.NewMultiMatchQuery(params.AccountName, "receipt.receiver", "act.authorization.actor"))
-	count, err := client.Count(index).
-		Query(query).
-		Do(context.Background())
-	return count, err
+	switch params.Match {
+	case MatchReceiver:
+		query = query.Filter(elastic.NewMatchQuery("receipt.receiver", params.AccountName))
+	case MatchActor:
+		query = query.Filter(elastic.NewMatchQuery("act.authorization.actor", params.AccountName))
+	default:
+		query = query.Filter(elastic.NewMultiMatchQuery(params.AccountName, "receipt.receiver", "act.authorization.actor"))
+	}
+	if len(params.Contract) > 0 {
+		query = query.Filter(elastic.NewMatchQuery("act.account", params.Contract))
+	}
+	if len(params.ActionName) > 0 {
+		query = query.Filter(elastic.NewMatchQuery("act.name", params.ActionName))
+	}
+	if len(params.After) > 0 || len(params.Before) > 0 {
+		timeRange := elastic.NewRangeQuery("block_time")
+		if len(params.After) > 0 {
+			timeRange = timeRange.Gte(params.After)
+		}
+		if len(params.Before) > 0 {
+			timeRange = timeRange.Lte(params.Before)
+		}
+		query = query.Filter(timeRange)
+	}
+	if params.BlockNumFrom != nil || params.BlockNumTo != nil {
+		blockRange := elastic.NewRangeQuery("block_num")
+		if params.BlockNumFrom != nil {
+			blockRange = blockRange.Gte(*params.BlockNumFrom)
+		}
+		if params.BlockNumTo != nil {
+			blockRange = blockRange.Lte(*params.BlockNumTo)
+		}
+		query = query.Filter(blockRange)
+	}
+	return query
+}
+
+//validateActionsParams checks optional get_actions filters
+func validateActionsParams(params GetActionsParams) error {
+	if len(params.AccountName) == 0 {
+		return errors.New("account_name is required")
+	}
+	if params.Match != "" && params.Match != MatchAny && params.Match != MatchReceiver && params.Match != MatchActor {
+		return errors.New("match must be one of: any, receiver, actor")
+	}
+	if params.BlockNumFrom != nil && params.BlockNumTo != nil && *params.BlockNumFrom > *params.BlockNumTo {
+		return errors.New("block_num_from is greater than block_num_to")
+	}
+	if params.Order != "" && params.Order != OrderAsc && params.Order != OrderDesc {
+		return errors.New("order must be one of: asc, desc")
+	}
+	if params.Limit != nil && (*params.Limit <= 0 || *params.Limit > MaxCursorLimit) {
+		return errors.New("limit must be between 1 and " + strconv.FormatInt(MaxCursorLimit, 10))
+	}
+	if params.Cursor != nil && len(*params.Cursor) > 0 {
+		cursor, err := decodeActionsCursor(*params.Cursor)
+		if err != nil {
+			return err
+		}
+		if cursor.Filters != actionsCursorFilters(params) {
+			return errors.New("Filters don't match the cursor, request the first page with new filters")
+		}
+	}
+	return nil
 }
 
 
-func getActions(client *elastic.Client, params GetActionsParams, indices map[string][]string) (*GetActionsResult, error) {
+func getActions(client *elastic.Client, params GetActionsParams, indices map[string][]string, indicesInfo map[string]IndexInfo, counts *ActionCountCache) (*GetActionsResult, error) {
 	result := new(GetActionsResult)
 	result.Actions = make([]Action, 0)
 	ascOrder := true
@@ -219,10 +493,14 @@ func getActions(client *elastic.Client, params GetActionsParams, indices map[str
 	var lastSize *int
 	targetIndices := make([]string, 0)
 	actionsPerTargetIndex := make([]int64, 0)
-	actionsPerIndex := make([]int64, 0, indexNum)
-	for _, index := range orderedIndices {
-		count, _ := countActions(client, params, index)
-		actionsPerIndex = append(actionsPerIndex, count)
+	actionsPerIndex, err := counts.get(client, params, indices[ActionTracesIndexPrefix])
+	if err != nil {
+		return nil, err
+	}
+	if !ascOrder {
+		for i, j := 0, indexNum - 1; i < j; i, j = i + 1, j - 1 {
+			actionsPerIndex[i], actionsPerIndex[j] = actionsPerIndex[j], actionsPerIndex[i]
+		}
 	}
 	totalActions := uint64(0)
 	for _, value := range actionsPerIndex {
@@ -252,8 +530,7 @@ func getActions(client *elastic.Client, params GetActionsParams, indices map[str
 		return result, nil
 	}
 	
-	query := elastic.NewBoolQuery()
-	query = query.Must(elastic.NewMultiMatchQuery(params.AccountName, "receipt.receiver", "act.authorization.actor"))
+	query := newActionsQuery(params)
 	msearch := client.MultiSearch()
 	for i, index := range targetIndices {
 		sreq := elastic.NewSearchRequest().
@@ -290,85 +567,280 @@ func getActions(client *elastic.Client, params GetActionsParams, indices map[str
 	}
 	msearchResult.Responses = nil
 	
-	result.Actions = make([]Action, 0, len(searchHits))
+	actions, err := createActions(client, searchHits, indices, indicesInfo, func(i int) *uint64 {
+		accountActionSeq := new(uint64)
+		if ascOrder {
+			*accountActionSeq = uint64(*params.Pos) + uint64(i)
+		} else {
+			*accountActionSeq = totalActions - (uint64(*params.Pos) + uint64(i + 1))
+		}
+		return accountActionSeq
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Actions = actions
+	return result, nil
+}
+
+
+//createActions composes get_actions result items from action_traces search hits
+//traces of all actions are requested from transaction_traces indices in one batch
+//accountActionSeq returns account_action_seq for the hit with given position (or nil)
+func createActions(client *elastic.Client, searchHits []elastic.SearchHit, indices map[string][]string, indicesInfo map[string]IndexInfo, accountActionSeq func(int) *uint64) ([]Action, error) {
+	actionTraces := make([]*ActionTrace, len(searchHits))
+	blockNums := make(map[string]uint64)
 	for i, hit := range searchHits {
 		if hit.Source == nil {
 			continue
 		}
-
-		var accountActionSeq uint64
-		if ascOrder {
-			accountActionSeq = uint64(*params.Pos) + uint64(i)
-		} else {
-			accountActionSeq = totalActions - (uint64(*params.Pos) + uint64(i + 1))
+		actionTrace := new(ActionTrace)
+		err := json.Unmarshal(*hit.Source, actionTrace)
+		if err != nil {
+			continue
 		}
+		actionTraces[i] = actionTrace
+		blockNums[actionTrace.TrxId], _ = parseUint64(actionTrace.BlockNum)
+	}
+	txTraces, err := getTransactionTraces(client, blockNums, indices, indicesInfo)
+	if err != nil {
+		return nil, err
+	}
 
-		var actionTrace ActionTrace
-		err = json.Unmarshal(*hit.Source, &actionTrace)
-		if err != nil {
+	actions := make([]Action, 0, len(searchHits))
+	for i, actionTrace := range actionTraces {
+		if actionTrace == nil || txTraces[actionTrace.TrxId] == nil {
 			continue
 		}
-		trace, err := getActionTrace(client, actionTrace.TrxId, actionTrace.Receipt.GlobalSequence, indices)
+		trace, err := formatActionTrace(txTraces[actionTrace.TrxId], actionTrace.Receipt.GlobalSequence)
 		if err != nil {
 			continue
 		}
 		action := Action { GlobalActionSeq: actionTrace.Receipt.GlobalSequence,
-			AccountActionSeq: accountActionSeq,
 			BlockNum: actionTrace.BlockNum, BlockTime: actionTrace.BlockTime,
 			ActionTrace: trace }
-		result.Actions = append(result.Actions, action)
+		if seq := accountActionSeq(i); seq != nil {
+			action.AccountActionSeq = *seq
+		} else {
+			action.OmitAccountActionSeq = true
+		}
+		actions = append(actions, action)
 	}
-	return result, nil
+	return actions, nil
 }
 
 
-func getTransaction(client *elastic.Client, params GetTransactionParams, indices map[string][]string) (*GetTransactionResult, *ErrorWithCode) {
-	mgetTx := client.MultiGet()
-	mgetTxTrace := client.MultiGet()
-	for _, index := range indices[TransactionsIndexPrefix] {
-		mgetTx.Add(elastic.NewMultiGetItem().Index(index).Id(params.Id))
+//actionsCursorFilters returns hash of filters of get_actions request
+//it is stored in cursors, so that filters can't be changed during pagination
+func actionsCursorFilters(params GetActionsParams) string {
+	hash := sha256.Sum256([]byte(actionCountKey(params)))
+	return base64.RawURLEncoding.EncodeToString(hash[:9])
+}
+
+//MarshalJSON omits account_action_seq if it is unknown
+//and keeps it a plain value otherwise
+func (a Action) MarshalJSON() ([]byte, error) {
+	type action Action
+	if !a.OmitAccountActionSeq {
+		return json.Marshal(action(a))
 	}
-	for _, index := range indices[TransactionTracesIndexPrefix] {
-		mgetTxTrace.Add(elastic.NewMultiGetItem().Index(index).Id(params.Id))
+	return json.Marshal(struct {
+		action
+		AccountActionSeq *uint64 `json:"account_action_seq,omitempty"`
+	}{ action: action(a) })
+}
+
+
+//encodeActionsCursor returns opaque cursor string
+func encodeActionsCursor(cursor ActionsCursor) string {
+	bytes, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeActionsCursor(str string) (*ActionsCursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, errors.New("Invalid cursor")
 	}
-	mgetTxResult, err := mgetTx.Do(context.Background())
-	if err != nil || mgetTxResult == nil || mgetTxResult.Docs == nil {
-		error := new(ErrorWithCode)
-		error.Error = err
-		error.Code = 500
-		return nil, error
+	cursor := new(ActionsCursor)
+	err = json.Unmarshal(bytes, cursor)
+	if err != nil || (cursor.Order != OrderAsc && cursor.Order != OrderDesc) {
+		return nil, errors.New("Invalid cursor")
 	}
-	mgetTxTraceResult, err := mgetTxTrace.Do(context.Background())
-	if err != nil || mgetTxTraceResult == nil || mgetTxTraceResult.Docs == nil {
-		error := new(ErrorWithCode)
-		error.Error = err
-		error.Code = 500
-		return nil, error
+	return cursor, nil
+}
+
+
+//getActionsByCursor returns page of actions which global sequences follow
+//(or precede, for cursors pointing backwards) the global sequence stored in the cursor
+//action_traces indices are searched with search_after,
+//so the depth of the page doesn't matter
+func getActionsByCursor(client *elastic.Client, params GetActionsParams, indices map[string][]string, indicesInfo map[string]IndexInfo) (*GetActionsResult, error) {
+	limit := int(DefaultCursorLimit)
+	if params.Limit != nil {
+		limit = int(*params.Limit)
+	}
+	cursor := &ActionsCursor{ Order: OrderDesc }
+	if params.Order == OrderAsc {
+		cursor.Order = OrderAsc
+	}
+	firstPage := true
+	if len(*params.Cursor) > 0 {
+		var err error
+		cursor, err = decodeActionsCursor(*params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		firstPage = false
 	}
+	//direction in which actions are searched
+	ascOrder := (cursor.Order == OrderAsc) != cursor.Backward
 
-	var getTxResult *elastic.GetResult
-	for _, doc := range mgetTxResult.Docs {
-		if doc == nil || doc.Error != nil || !doc.Found {
-			continue
+	indexNum := len(indices[ActionTracesIndexPrefix])
+	orderedIndices := make([]string, 0, indexNum)
+	for i, _ := range indices[ActionTracesIndexPrefix] {
+		if ascOrder {
+			orderedIndices = append(orderedIndices, indices[ActionTracesIndexPrefix][i])
+		} else {
+			orderedIndices = append(orderedIndices, indices[ActionTracesIndexPrefix][indexNum-1-i])
 		}
-		getTxResult = doc
 	}
-	var getTxTraceResult *elastic.GetResult
-	for _, doc := range mgetTxTraceResult.Docs {
-		if doc == nil || doc.Error != nil || !doc.Found {
+	result := new(GetActionsResult)
+	result.Actions = make([]Action, 0)
+	if len(orderedIndices) == 0 {
+		return result, nil
+	}
+
+	type seqHit struct {
+		seq uint64
+		hit elastic.SearchHit
+	}
+	hits := make([]seqHit, 0)
+	query := newActionsQuery(params)
+	//indices are searched one by one in the order of the page
+	//until the page is full, so only the indices it spans are requested
+	for _, index := range orderedIndices {
+		source := elastic.NewSearchSource().Query(query).
+			Sort("receipt.global_sequence", ascOrder).
+			Size(limit + 1 - len(hits))
+		if !firstPage {
+			source = source.SearchAfter(cursor.Seq)
+		}
+		searchResult, err := client.Search(index).SearchSource(source).Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if searchResult == nil || searchResult.Hits == nil {
 			continue
 		}
-		getTxTraceResult = doc
+		for _, hit := range searchResult.Hits.Hits {
+			if hit == nil || hit.Source == nil {
+				continue
+			}
+			var actionTrace ActionTrace
+			err = json.Unmarshal(*hit.Source, &actionTrace)
+			if err != nil {
+				continue
+			}
+			seq, err := parseUint64(actionTrace.Receipt.GlobalSequence)
+			if err != nil {
+				continue
+			}
+			hits = append(hits, seqHit{ seq: seq, hit: *hit })
+		}
+		if len(hits) > limit {
+			break
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if ascOrder {
+			return hits[i].seq < hits[j].seq
+		}
+		return hits[i].seq > hits[j].seq
+	})
+	hasMore := len(hits) > limit
+	if hasMore {
+		hits = hits[:limit]
+	}
+	//page is always returned in the order of the cursor
+	if cursor.Backward {
+		for i, j := 0, len(hits) - 1; i < j; i, j = i + 1, j - 1 {
+			hits[i], hits[j] = hits[j], hits[i]
+		}
+	}
+	if len(hits) == 0 {
+		return result, nil
+	}
+
+	searchHits := make([]elastic.SearchHit, 0, len(hits))
+	for _, hit := range hits {
+		searchHits = append(searchHits, hit.hit)
+	}
+	var err error
+	result.Actions, err = createActions(client, searchHits, indices, indicesInfo, func(int) *uint64 { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	first := hits[0].seq
+	last := hits[len(hits) - 1].seq
+	filters := actionsCursorFilters(params)
+	if hasMore || cursor.Backward {
+		result.NextCursor = encodeActionsCursor(ActionsCursor{ Seq: last, Order: cursor.Order, Filters: filters })
+	}
+	if (hasMore && cursor.Backward) || (!cursor.Backward && !firstPage) {
+		result.PrevCursor = encodeActionsCursor(ActionsCursor{ Seq: first, Order: cursor.Order, Backward: true, Filters: filters })
 	}
+	return result, nil
+}
 
+
+func getTransaction(client *elastic.Client, params GetTransactionParams, indices map[string][]string, indicesInfo map[string]IndexInfo) (*GetTransactionResult, *ErrorWithCode) {
+	var blockNumHint uint64
+	if params.BlockNumHint != nil {
+		blockNumHint = *params.BlockNumHint
+	}
+	getTxTraceResult, err := multiGetById(client,
+		routeIndices(indices[TransactionTracesIndexPrefix], indicesInfo, blockNumHint), params.Id)
+	if err == nil && getTxTraceResult == nil && blockNumHint != 0 {
+		//hint might be wrong, search in all indices
+		blockNumHint = 0
+		getTxTraceResult, err = multiGetById(client, indices[TransactionTracesIndexPrefix], params.Id)
+	}
+	if err != nil {
+		error := new(ErrorWithCode)
+		error.Error = err
+		error.Code = 500
+		return nil, error
+	}
 	if getTxTraceResult == nil || !getTxTraceResult.Found {
 		error := new(ErrorWithCode)
 		error.Error = errors.New("Transaction not found.")
 		error.Code = 404
 		return nil, error
 	}
+	if blockNumHint == 0 {
+		//block num of found trace is the most precise hint for transactions indices
+		var txTrace struct {
+			BlockNum json.RawMessage `json:"block_num"`
+		}
+		if getTxTraceResult.Source != nil && json.Unmarshal(*getTxTraceResult.Source, &txTrace) == nil {
+			blockNumHint, _ = parseUint64(txTrace.BlockNum)
+		}
+	}
+	getTxResult, err := multiGetById(client,
+		routeIndices(indices[TransactionsIndexPrefix], indicesInfo, blockNumHint), params.Id)
+	if err == nil && getTxResult == nil && blockNumHint != 0 {
+		getTxResult, err = multiGetById(client, indices[TransactionsIndexPrefix], params.Id)
+	}
+	if err != nil {
+		error := new(ErrorWithCode)
+		error.Error = err
+		error.Code = 500
+		return nil, error
+	}
 
-	result, error := createTransaction(getTxResult, getTxTraceResult)
+	result, error := createTransaction(getTxResult, getTxTraceResult, params.ShowFailed)
 	if error != nil {
 		return nil, error
 	}
@@ -379,7 +851,9 @@ func getTransaction(client *elastic.Client, params GetTransactionParams, indices
 
 //gets info from transactions and transaction_traces indices
 //and composes return value for get_transaction
-func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic.GetResult) (*GetTransactionResult, *ErrorWithCode) {
+//hard failed transactions are not found unless showFailed is set,
+//in this case receipt status and parsed exception are added to the result
+func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic.GetResult, showFailed bool) (*GetTransactionResult, *ErrorWithCode) {
 	//prepare data from transaction_traces index
 	var txTrace TransactionTrace
 	err := json.Unmarshal(*getTxTraceResult.Source, &txTrace)
@@ -397,7 +871,7 @@ func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic
 		error.Code = 500
 		return nil, error
 	}
-	if status == "hard_fail" {
+	if status == "hard_fail" && !showFailed {
 		error := new(ErrorWithCode)
 		error.Error = errors.New("Transaction not found")
 		error.Code = 404
@@ -407,6 +881,10 @@ func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic
 	result.Trx = make(map[string]json.RawMessage)
 	result.BlockTime = txTrace.BlockTime
 	result.BlockNum = txTrace.BlockNum
+	if showFailed {
+		result.Status = status
+		result.Except = transactionException(&txTrace)
+	}
 	//recursively replace json abi with bytes
 	convertAbiToBytes(txTrace.ActionTraces)
 	result.Traces, err = json.Marshal(txTrace.ActionTraces)
@@ -479,31 +957,396 @@ func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic
 }
 
 
-func getKeyAccounts(client *elastic.Client, params GetKeyAccountsParams, indices map[string][]string) (*GetKeyAccountsResult, error) {
-	query := elastic.NewBoolQuery()
-	query = query.Filter(elastic.NewMatchQuery("pub_keys.key", params.PublicKey))
-	msearch := client.MultiSearch()
-	for _, index := range indices[AccountsIndexPrefix] {
-		msearch.Add(elastic.NewSearchRequest().Index(index).Query(query).Size(MaxQuerySize))
+//getAccountDocuments returns documents of given accounts from accounts indices
+//if account is found in several indices, document from the newest index is used
+func getAccountDocuments(client *elastic.Client, names []string, indices map[string][]string) (map[string]*Account, error) {
+	result := make(map[string]*Account)
+	if len(names) == 0 || len(indices[AccountsIndexPrefix]) == 0 {
+		return result, nil
 	}
-	msearchResult, err := msearch.Do(context.Background())
-	if err != nil || msearchResult == nil || msearchResult.Responses == nil {
+	query := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, name := range names {
+		query = query.Should(elastic.NewMatchQuery("name", name))
+	}
+	searchResult, err := client.Search(indices[AccountsIndexPrefix]...).
+		Query(elastic.NewBoolQuery().Filter(query)).
+		Size(len(names) * len(indices[AccountsIndexPrefix])).
+		Do(context.Background())
+	if err != nil {
 		return nil, err
 	}
-	var searchHits []elastic.SearchHit
-	for _, resp := range msearchResult.Responses {
-		if resp == nil || resp.Error != nil {
+	if searchResult == nil || searchResult.Hits == nil {
+		return nil, errors.New("Empty ES response")
+	}
+	indexOrder := make(map[string]int)
+	for i, index := range indices[AccountsIndexPrefix] {
+		indexOrder[index] = i
+	}
+	foundIn := make(map[string]int)
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
 			continue
 		}
-		for _, hit := range resp.Hits.Hits {
-			if hit != nil {
-				searchHits = append(searchHits, *hit)
-			}
+		account := new(Account)
+		err = json.Unmarshal(*hit.Source, account)
+		if err != nil {
+			return nil, errors.New("Failed to parse ES response")
+		}
+		if prev, ok := foundIn[account.Name]; ok && prev > indexOrder[hit.Index] {
+			continue
 		}
+		foundIn[account.Name] = indexOrder[hit.Index]
+		result[account.Name] = account
 	}
+	return result, nil
+}
 
-	result := new(GetKeyAccountsResult)
+//getNewAccountActions returns eosio::newaccount actions which created given accounts
+func getNewAccountActions(client *elastic.Client, names []string, indices map[string][]string) (map[string]*ActionTrace, error) {
+	result := make(map[string]*ActionTrace)
+	if len(names) == 0 {
+		return result, nil
+	}
+	accountNames := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, name := range names {
+		accountNames = accountNames.Should(elastic.NewMatchQuery("act.data.name", name))
+	}
+	query := newSystemActionQuery("newaccount").Filter(accountNames)
+	searchResult, err := searchActionTraces(client, indices[ActionTracesIndexPrefix], query, 0, len(names), true)
+	if err != nil {
+		return nil, err
+	}
+	if searchResult == nil || searchResult.Hits == nil {
+		return result, nil
+	}
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		actionTrace := new(ActionTrace)
+		var data NewAccountData
+		err = json.Unmarshal(*hit.Source, actionTrace)
+		if err == nil {
+			err = json.Unmarshal(actionTrace.Act.Data, &data)
+		}
+		if err != nil {
+			return nil, errors.New("Failed to parse ES response")
+		}
+		if _, ok := result[data.Name]; !ok {
+			result[data.Name] = actionTrace
+		}
+	}
+	return result, nil
+}
+
+//getAccounts returns account documents with creation transaction
+//accounts which are not found in accounts indices are listed in NotFound
+func getAccounts(client *elastic.Client, names []string, indices map[string][]string) (*GetAccountsResult, *ErrorWithCode) {
+	if len(names) > MaxAccountsPerRequest {
+		return nil, newErrorWithCode(400, errors.New("Too many accounts, max is " + strconv.Itoa(MaxAccountsPerRequest)))
+	}
+	unique := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if len(name) == 0 {
+			return nil, newErrorWithCode(400, errors.New("Account name is empty"))
+		}
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	accounts, err := getAccountDocuments(client, unique, indices)
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	newAccountActions, err := getNewAccountActions(client, unique, indices)
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	result := new(GetAccountsResult)
+	result.Accounts = make([]GetAccountResult, 0, len(unique))
+	result.NotFound = make([]string, 0)
+	for _, name := range unique {
+		account, ok := accounts[name]
+		if !ok {
+			result.NotFound = append(result.NotFound, name)
+			continue
+		}
+		item := GetAccountResult { AccountName: account.Name, Creator: account.Creator,
+			AccountCreateTime: account.AccountCreateTime, PubKeys: account.PubKeys, Abi: account.Abi }
+		item.AccountControls, err = json.Marshal(account.AccountControls)
+		if err != nil {
+			return nil, newErrorWithCode(500, err)
+		}
+		if actionTrace, ok := newAccountActions[name]; ok {
+			item.CreatedTrxId = actionTrace.TrxId
+			item.CreatedBlockNum = actionTrace.BlockNum
+		}
+		result.Accounts = append(result.Accounts, item)
+	}
+	return result, nil
+}
+
+
+//AccountCreation describes creation of an account
+//it is taken from eosio::newaccount action, account document is used only if the action is not indexed
+type AccountCreation struct {
+	Creator          string
+	Timestamp json.RawMessage
+	BlockNum  json.RawMessage
+	TrxId            string
+}
+
+//getAccountCreation returns creation of account or nil if neither its newaccount action
+//nor its document in accounts indices is found
+func getAccountCreation(client *elastic.Client, name string, indices map[string][]string) (*AccountCreation, error) {
+	actions, err := getNewAccountActions(client, []string{ name }, indices)
+	if err != nil {
+		return nil, err
+	}
+	if actionTrace, ok := actions[name]; ok {
+		var data NewAccountData
+		json.Unmarshal(actionTrace.Act.Data, &data)
+		return &AccountCreation { Creator: data.Creator, Timestamp: actionTrace.BlockTime,
+			BlockNum: actionTrace.BlockNum, TrxId: actionTrace.TrxId }, nil
+	}
+	accounts, err := getAccountDocuments(client, []string{ name }, indices)
+	if err != nil {
+		return nil, err
+	}
+	account, ok := accounts[name]
+	if !ok {
+		return nil, nil
+	}
+	result := &AccountCreation { Timestamp: account.AccountCreateTime }
+	json.Unmarshal(account.Creator, &result.Creator)
+	return result, nil
+}
+
+//getCreator returns creator of the account and creation block and transaction
+func getCreator(client *elastic.Client, params GetCreatorParams, indices map[string][]string) (*GetCreatorResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name is required"))
+	}
+	creation, err := getAccountCreation(client, params.AccountName, indices)
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	if creation == nil {
+		return nil, newErrorWithCode(404, errors.New("Account not found"))
+	}
+	result := new(GetCreatorResult)
+	result.AccountName = params.AccountName
+	result.Creator = creation.Creator
+	result.AccountCreateTime = creation.Timestamp
+	result.CreatedBlockNum = creation.BlockNum
+	result.CreatedTrxId = creation.TrxId
+	return result, nil
+}
+
+
+//searchAccountsByCreator returns a page of accounts created by any of creators in all accounts indices
+//accounts are sorted by creation time and name and collapsed by name in ES,
+//so that an account found in several indices is returned once
+//returns the page and the number of distinct found accounts
+func searchAccountsByCreator(client *elastic.Client, creators []string, indices map[string][]string, from int, size int) ([]Account, int64, error) {
+	result := make([]Account, 0)
+	if len(creators) == 0 || len(indices[AccountsIndexPrefix]) == 0 {
+		return result, 0, nil
+	}
+	creatorsQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, creator := range creators {
+		creatorsQuery = creatorsQuery.Should(elastic.NewMatchQuery("creator", creator))
+	}
+	searchResult, err := client.Search(indices[AccountsIndexPrefix]...).
+		Query(elastic.NewBoolQuery().Filter(creatorsQuery)).
+		Sort("account_create_time", true).
+		Sort("name.keyword", true).
+		Collapse(elastic.NewCollapseBuilder("name.keyword")).
+		Aggregation("accounts", elastic.NewCardinalityAggregation().Field("name.keyword").PrecisionThreshold(AccountsCountPrecision)).
+		From(from).
+		Size(size).
+		Do(context.Background())
+	if err != nil {
+		return nil, 0, err
+	}
+	if searchResult == nil || searchResult.Hits == nil {
+		return nil, 0, errors.New("Empty ES response")
+	}
+	isCreator := make(map[string]bool)
+	for _, creator := range creators {
+		isCreator[creator] = true
+	}
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		var account Account
+		err = json.Unmarshal(*hit.Source, &account)
+		if err != nil {
+			return nil, 0, errors.New("Failed to parse ES response")
+		}
+		var creator string
+		json.Unmarshal(account.Creator, &creator)
+		if !isCreator[creator] {
+			continue
+		}
+		result = append(result, account)
+	}
+	total := searchResult.Hits.TotalHits
+	if count, ok := searchResult.Aggregations.Cardinality("accounts"); ok && count.Value != nil {
+		total = int64(*count.Value)
+	}
+	return result, total, nil
+}
+
+//getCreatedAccounts returns a page of accounts created by the account
+//ordered by creation time
+func getCreatedAccounts(client *elastic.Client, params GetCreatedAccountsParams, indices map[string][]string) (*GetCreatedAccountsResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name is required"))
+	}
+	var pos int64
+	limit := DefaultCreatedAccountsLimit
+	if params.Pos != nil {
+		pos = *params.Pos
+	}
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if pos < 0 || limit <= 0 || pos + limit > int64(MaxQuerySize) {
+		return nil, newErrorWithCode(400, errors.New("Invalid pos or limit"))
+	}
+	accounts, total, err := searchAccountsByCreator(client, []string{ params.AccountName }, indices, int(pos), int(limit))
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	result := new(GetCreatedAccountsResult)
+	result.Total = total
+	result.Accounts = make([]CreatedAccount, 0, len(accounts))
+	for _, account := range accounts {
+		result.Accounts = append(result.Accounts, CreatedAccount { AccountName: account.Name,
+			AccountCreateTime: account.AccountCreateTime })
+	}
+	return result, nil
+}
+
+//getAccountTree returns tree of accounts created by the account and their descendants
+//tree is built level by level up to given depth, every level is requested with one msearch
+//accounts which are already in the tree are skipped to guard against cycles,
+//tree is truncated when it reaches MaxAccountTreeSize nodes
+func getAccountTree(client *elastic.Client, params GetAccountTreeParams, indices map[string][]string) (*GetAccountTreeResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name is required"))
+	}
+	depth := DefaultAccountTreeDepth
+	if params.Depth != nil {
+		depth = *params.Depth
+	}
+	if depth < 1 || depth > MaxAccountTreeDepth {
+		return nil, newErrorWithCode(400, errors.New("depth must be between 1 and " + strconv.Itoa(MaxAccountTreeDepth)))
+	}
+	result := new(GetAccountTreeResult)
+	result.Tree = &AccountTreeNode { AccountName: params.AccountName }
+	visited := map[string]bool { params.AccountName: true }
+	size := 1
+	level := []*AccountTreeNode{ result.Tree }
+	for d := 0; d < depth && len(level) > 0; d++ {
+		nextLevel := make([]*AccountTreeNode, 0)
+		//request children of the level in batches to keep queries small
+		for start := 0; start < len(level); start += MaxAccountsPerRequest {
+			end := start + MaxAccountsPerRequest
+			if end > len(level) {
+				end = len(level)
+			}
+			creators := make([]string, 0, end - start)
+			for _, node := range level[start:end] {
+				creators = append(creators, node.AccountName)
+			}
+			//one more account than the tree can take shows that it is truncated
+			accounts, total, err := searchAccountsByCreator(client, creators, indices, 0, MaxAccountTreeSize - size + 1)
+			if err != nil {
+				return nil, newErrorWithCode(500, err)
+			}
+			if total > int64(len(accounts)) {
+				result.Truncated = true
+			}
+			children := make(map[string][]Account)
+			for _, account := range accounts {
+				var creator string
+				json.Unmarshal(account.Creator, &creator)
+				children[creator] = append(children[creator], account)
+			}
+			for _, node := range level[start:end] {
+				for _, child := range children[node.AccountName] {
+					if visited[child.Name] {
+						continue
+					}
+					if size >= MaxAccountTreeSize {
+						result.Truncated = true
+						return result, nil
+					}
+					visited[child.Name] = true
+					size++
+					childNode := &AccountTreeNode { AccountName: child.Name, AccountCreateTime: child.AccountCreateTime }
+					node.Children = append(node.Children, childNode)
+					nextLevel = append(nextLevel, childNode)
+				}
+			}
+		}
+		level = nextLevel
+	}
+	return result, nil
+}
+
+
+//getKeyAccounts searches accounts that have the public key in any of its formats
+//and returns permissions which the key belongs to with the key weight
+func getKeyAccounts(client *elastic.Client, params GetKeyAccountsParams, indices map[string][]string) (*GetKeyAccountsResult, *ErrorWithCode) {
+	keyType, key, err := parsePublicKey(params.PublicKey)
+	if err != nil {
+		return nil, newErrorWithCode(400, err)
+	}
+	formats, err := publicKeyFormats(keyType, key)
+	if err != nil {
+		return nil, newErrorWithCode(400, err)
+	}
+	normalized := formats[0]
+	keyQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, format := range formats {
+		keyQuery = keyQuery.Should(elastic.NewMatchQuery("pub_keys.key", format))
+	}
+	query := elastic.NewBoolQuery()
+	query = query.Filter(keyQuery)
+	msearch := client.MultiSearch()
+	for _, index := range indices[AccountsIndexPrefix] {
+		msearch.Add(elastic.NewSearchRequest().Index(index).Query(query).Size(MaxQuerySize))
+	}
+	msearchResult, err := msearch.Do(context.Background())
+	if err != nil || msearchResult == nil || msearchResult.Responses == nil {
+		if err == nil {
+			err = errors.New("Empty ES response")
+		}
+		return nil, newErrorWithCode(500, err)
+	}
+	var searchHits []elastic.SearchHit
+	for _, resp := range msearchResult.Responses {
+		if resp == nil || resp.Error != nil {
+			continue
+		}
+		for _, hit := range resp.Hits.Hits {
+			if hit != nil {
+				searchHits = append(searchHits, *hit)
+			}
+		}
+	}
+
+	result := new(GetKeyAccountsResult)
 	result.AccountNames = make([]string, 0, len(searchHits))
+	result.Permissions = make([]KeyPermission, 0, len(searchHits))
+	seenAccounts := make(map[string]bool)
+	permissions := make([]permissionKey, 0)
 	for _, hit := range searchHits {
 		if hit.Source == nil {
 			continue
@@ -511,11 +1354,59 @@ func getKeyAccounts(client *elastic.Client, params GetKeyAccountsParams, indices
 		var account Account
 		err := json.Unmarshal(*hit.Source, &account)
 		if err != nil {
-			return nil, errors.New("Failed to parse ES response")
+			return nil, newErrorWithCode(500, errors.New("Failed to parse ES response"))
+		}
+		if seenAccounts[account.Name] {
+			continue
 		}
+		var pubKeys []struct {
+			Key        string `json:"key"`
+			Permission string `json:"permission"`
+		}
+		json.Unmarshal(account.PubKeys, &pubKeys)
+		matched := make([]permissionKey, 0)
+		for _, pubKey := range pubKeys {
+			//match query is not exact, compare keys in the same format
+			if k, err := normalizePublicKey(pubKey.Key); err != nil || k != normalized {
+				continue
+			}
+			matched = append(matched, permissionKey { Account: account.Name, Permission: pubKey.Permission })
+		}
+		//account is returned only if one of its keys is really the requested one
+		if len(matched) == 0 {
+			continue
+		}
+		seenAccounts[account.Name] = true
 		result.AccountNames = append(result.AccountNames, account.Name)
+		permissions = append(permissions, matched...)
 	}
 	sort.Strings(result.AccountNames)
+
+	authorities, err := getPermissionAuthorities(client, permissions, indices)
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	for _, permission := range permissions {
+		item := KeyPermission { AccountName: permission.Account, Permission: permission.Permission }
+		if authority, ok := authorities[permission]; ok {
+			for _, authorityKey := range authority.Keys {
+				if k, err := normalizePublicKey(authorityKey.Key); err != nil || k != normalized {
+					continue
+				}
+				if weight, err := parseUint64(authorityKey.Weight); err == nil {
+					item.Weight = &weight
+				}
+				break
+			}
+		}
+		result.Permissions = append(result.Permissions, item)
+	}
+	sort.Slice(result.Permissions, func(i, j int) bool {
+		if result.Permissions[i].AccountName != result.Permissions[j].AccountName {
+			return result.Permissions[i].AccountName < result.Permissions[j].AccountName
+		}
+		return result.Permissions[i].Permission < result.Permissions[j].Permission
+	})
 	return result, nil
 }
 
@@ -558,4 +1449,409 @@ func getControlledAccounts(client *elastic.Client, params GetControlledAccountsP
 	}
 	sort.Strings(result.ControlledAccounts)
 	return result, nil
-}
\ No newline at end of file
+}
+
+
+//getBlock searches block by number or id in blocks indices
+func getBlock(client *elastic.Client, params GetBlockParams, indices map[string][]string, indicesInfo map[string]IndexInfo) (*Block, *ErrorWithCode) {
+	var blockNumOrId interface{}
+	err := json.Unmarshal(params.BlockNum, &blockNumOrId)
+	if err != nil {
+		error := new(ErrorWithCode)
+		error.Error = errors.New("Invalid block_num_or_id")
+		error.Code = 400
+		return nil, error
+	}
+	var query elastic.Query
+	var blockNum uint64
+	if num, ok := blockNumOrId.(float64); ok {
+		blockNum = uint64(num)
+		query = elastic.NewTermQuery("block_num", blockNum)
+	} else if str, ok := blockNumOrId.(string); ok && len(str) > 0 {
+		if num, err := strconv.ParseUint(str, 10, 64); err == nil && len(str) < 64 {
+			blockNum = num
+			query = elastic.NewTermQuery("block_num", blockNum)
+		} else {
+			//block id is matched exactly, blocks indexed without block_id field are found by document id
+			query = elastic.NewBoolQuery().
+				Should(elastic.NewTermQuery("block_id.keyword", str)).
+				Should(elastic.NewIdsQuery().Ids(str))
+		}
+	} else {
+		error := new(ErrorWithCode)
+		error.Error = errors.New("Invalid block_num_or_id")
+		error.Code = 400
+		return nil, error
+	}
+
+	blocksIndices := routeIndices(indices[BlocksIndexPrefix], indicesInfo, blockNum)
+	if len(blocksIndices) == 0 {
+		error := new(ErrorWithCode)
+		error.Error = errors.New("Block not found")
+		error.Code = 404
+		return nil, error
+	}
+	msearch := client.MultiSearch()
+	for _, index := range blocksIndices {
+		msearch.Add(elastic.NewSearchRequest().Index(index).Query(query).Size(1))
+	}
+	msearchResult, err := msearch.Do(context.Background())
+	if err == nil && (msearchResult == nil || msearchResult.Responses == nil) {
+		err = errors.New("Empty ES response")
+	}
+	if err != nil {
+		error := new(ErrorWithCode)
+		error.Error = err
+		error.Code = 500
+		return nil, error
+	}
+	for _, resp := range msearchResult.Responses {
+		if resp == nil || resp.Error != nil || resp.Hits == nil {
+			continue
+		}
+		for _, hit := range resp.Hits.Hits {
+			if hit == nil || hit.Source == nil {
+				continue
+			}
+			block := new(Block)
+			err = json.Unmarshal(*hit.Source, block)
+			if err != nil {
+				error := new(ErrorWithCode)
+				error.Error = errors.New("Failed to parse ES response")
+				error.Code = 500
+				return nil, error
+			}
+			if len(block.BlockId) == 0 {
+				block.BlockId = hit.Id
+			}
+			return block, nil
+		}
+	}
+	error := new(ErrorWithCode)
+	error.Error = errors.New("Block not found")
+	error.Code = 404
+	return nil, error
+}
+
+
+//computeTransactionId returns id of packed transaction
+//which is sha256 of serialized (uncompressed) transaction
+func computeTransactionId(trx *TransactionFromBlock) (string, error) {
+	packed, _, err := unpackTransactionData(trx)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(packed)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+
+//parseBlockTransaction parses trx field of block transaction
+//which is stored either as [0, "id"] / [1, {packed transaction}] pair
+//or in the chain api format ("id" / {packed transaction})
+//returns transaction id and packed transaction (nil for deferred transactions)
+func parseBlockTransaction(raw json.RawMessage) (string, *TransactionFromBlock, error) {
+	var tmp interface{}
+	err := json.Unmarshal(raw, &tmp)
+	if err != nil {
+		return "", nil, err
+	}
+	if pair, ok := tmp.([]interface{}); ok {
+		var rawPair []json.RawMessage
+		err = json.Unmarshal(raw, &rawPair)
+		if err != nil || len(pair) != 2 {
+			return "", nil, errors.New("Invalid transaction format")
+		}
+		raw = rawPair[1]
+		tmp = pair[1]
+	}
+	if id, ok := tmp.(string); ok {
+		return id, nil, nil
+	}
+	trx := new(TransactionFromBlock)
+	err = json.Unmarshal(raw, trx)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(trx.Id) == 0 {
+		trx.Id, err = computeTransactionId(trx)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return trx.Id, trx, nil
+}
+
+
+//formatChainBlock composes block in the format of /v1/chain/get_block
+func formatChainBlock(block *Block) (map[string]json.RawMessage, error) {
+	result := make(map[string]json.RawMessage)
+	for key, value := range block.Block {
+		result[key] = value
+	}
+	var err error
+	result["id"], err = json.Marshal(block.BlockId)
+	if err != nil {
+		return nil, err
+	}
+	result["block_num"] = block.BlockNum
+	//ref_block_prefix is the second 32-bit word of block id (little endian)
+	id, err := hex.DecodeString(block.BlockId)
+	if err == nil && len(id) >= 12 {
+		result["ref_block_prefix"], err = json.Marshal(binary.LittleEndian.Uint32(id[8:12]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	var transactions []BlockTransaction
+	if block.Block["transactions"] != nil {
+		err = json.Unmarshal(block.Block["transactions"], &transactions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, _ := range transactions {
+		id, trx, err := parseBlockTransaction(transactions[i].Trx)
+		if err != nil {
+			return nil, err
+		}
+		if trx == nil {
+			transactions[i].Trx, err = json.Marshal(id)
+		} else {
+			transactions[i].Trx, err = json.Marshal(trx)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if transactions == nil {
+		transactions = make([]BlockTransaction, 0)
+	}
+	result["transactions"], err = json.Marshal(transactions)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+
+//takes blockNum and transactionId as arguments
+//retrieves block from blocks indices
+//searches requested transaction in retrieved block
+//returns the trx->trx field contents in the correct format
+func getTransactionFromEsBlock(client *elastic.Client, blockNum json.RawMessage, txId string, indices map[string][]string, indicesInfo map[string]IndexInfo) (json.RawMessage, error) {
+	block, error := getBlock(client, GetBlockParams { BlockNum: blockNum }, indices, indicesInfo)
+	if error != nil {
+		return nil, error.Error
+	}
+	var transactions []BlockTransaction
+	err := json.Unmarshal(block.Block["transactions"], &transactions)
+	if err != nil {
+		return nil, err
+	}
+	for _, transaction := range transactions {
+		id, trx, err := parseBlockTransaction(transaction.Trx)
+		if err != nil {
+			return nil, err
+		}
+		if id != txId {
+			continue
+		}
+		if trx == nil {
+			return json.Marshal([]interface{}{0, id})
+		}
+		trx.Id = ""
+		return json.Marshal([]interface{}{1, trx})
+	}
+	return nil, errors.New("Transaction not found")
+}
+
+
+//getAbis returns current abis of given accounts from accounts index
+//accounts without abi or with invalid abi are omitted
+func getAbis(client *elastic.Client, accounts []string, indices map[string][]string) (map[string]*Abi, error) {
+	result := make(map[string]*Abi)
+	if len(accounts) == 0 || len(indices[AccountsIndexPrefix]) == 0 {
+		return result, nil
+	}
+	query := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, account := range accounts {
+		query = query.Should(elastic.NewMatchQuery("name", account))
+	}
+	searchResult, err := client.Search(indices[AccountsIndexPrefix]...).
+		Query(elastic.NewBoolQuery().Filter(query)).
+		Size(len(accounts) * len(indices[AccountsIndexPrefix])).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if searchResult == nil || searchResult.Hits == nil {
+		return nil, errors.New("Empty ES response")
+	}
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		var account Account
+		err = json.Unmarshal(*hit.Source, &account)
+		if err != nil || result[account.Name] != nil {
+			continue
+		}
+		abi, err := parseAbi(account.Abi)
+		if err == nil {
+			result[account.Name] = abi
+		}
+	}
+	return result, nil
+}
+
+//transactionActionSeqs returns global sequences of trx actions taken from top level action traces of transaction
+//actions are matched with traces by contract and name in order, unmatched actions get zero
+func transactionActionSeqs(traces json.RawMessage, actions []*TransactionAction) []uint64 {
+	result := make([]uint64, len(actions))
+	var actionTraces []struct {
+		Receipt struct {
+			GlobalSequence json.RawMessage `json:"global_sequence"`
+		} `json:"receipt"`
+		Act struct {
+			Account string `json:"account"`
+			Name    string `json:"name"`
+		} `json:"act"`
+	}
+	if json.Unmarshal(traces, &actionTraces) != nil {
+		return result
+	}
+	used := make([]bool, len(actionTraces))
+	for i, action := range actions {
+		for j, trace := range actionTraces {
+			if used[j] || trace.Act.Account != action.Account || trace.Act.Name != action.Name {
+				continue
+			}
+			used[j] = true
+			result[i], _ = parseUint64(trace.Receipt.GlobalSequence)
+			break
+		}
+	}
+	return result
+}
+
+//decodeActionsData decodes hex_data of actions without data using abis of their contracts
+//which were active at the action (seqs are global sequences of actions, zero if unknown,
+//then abi active at given block is used), current abi from accounts index is used
+//only for contracts which setabi actions are not indexed
+func decodeActionsData(client *elastic.Client, history *AbiHistory, actions []*TransactionAction, seqs []uint64, blockNum uint64, indices map[string][]string) error {
+	abis := make([]*Abi, len(actions))
+	checked := make(map[string]bool)
+	withoutHistory := make([]string, 0)
+	for i, action := range actions {
+		if action.hasData() || len(action.HexData) == 0 {
+			continue
+		}
+		var seq uint64
+		if i < len(seqs) {
+			seq = seqs[i]
+		}
+		version, err := history.abiAt(action.Account, blockNum, seq)
+		if err != nil {
+			return err
+		}
+		if version != nil {
+			abis[i] = version.Abi
+			continue
+		}
+		if checked[action.Account] {
+			continue
+		}
+		checked[action.Account] = true
+		hasHistory, err := history.hasHistory(action.Account)
+		if err != nil {
+			return err
+		}
+		if !hasHistory {
+			withoutHistory = append(withoutHistory, action.Account)
+		}
+	}
+	currentAbis := make(map[string]*Abi)
+	if len(withoutHistory) > 0 {
+		var err error
+		currentAbis, err = getAbis(client, withoutHistory, indices)
+		if err != nil {
+			return err
+		}
+	}
+	for i, action := range actions {
+		if abis[i] != nil {
+			action.decodeData(map[string]*Abi{ action.Account: abis[i] })
+		} else {
+			action.decodeData(currentAbis)
+		}
+	}
+	return nil
+}
+
+
+//unpackBlockTransaction deserializes [1, {packed transaction}] pair of block transaction
+//into trx in get_transaction format with action data decoded by contract abis
+//traces are action traces of the transaction, they give global sequences of actions
+func unpackBlockTransaction(client *elastic.Client, history *AbiHistory, txFromBlock json.RawMessage, traces json.RawMessage, blockNum uint64, indices map[string][]string) (json.RawMessage, error) {
+	var pair []json.RawMessage
+	err := json.Unmarshal(txFromBlock, &pair)
+	if err != nil || len(pair) != 2 {
+		return nil, errors.New("Invalid transaction format")
+	}
+	var trx TransactionFromBlock
+	err = json.Unmarshal(pair[1], &trx)
+	if err != nil || len(trx.PackedTrx) == 0 {
+		//deferred transaction, there is nothing to unpack
+		return nil, errors.New("Packed transaction is missing")
+	}
+	result, contextFreeActions, actions, err := unpackTransaction(&trx)
+	if err != nil {
+		return nil, err
+	}
+	allActions := append(append([]*TransactionAction{}, contextFreeActions...), actions...)
+	err = decodeActionsData(client, history, allActions, transactionActionSeqs(traces, allActions), blockNum, indices)
+	if err != nil {
+		return nil, err
+	}
+	result["context_free_actions"], err = json.Marshal(contextFreeActions)
+	if err != nil {
+		return nil, err
+	}
+	result["actions"], err = json.Marshal(actions)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+//decodeMissingActionData decodes hex_data of trx actions which have no data in transactions index
+//traces are action traces of the transaction, they give global sequences of actions
+func decodeMissingActionData(client *elastic.Client, history *AbiHistory, trx json.RawMessage, traces json.RawMessage, blockNum uint64, indices map[string][]string) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(trx, &fields)
+	if err != nil {
+		return nil, err
+	}
+	var actions []*TransactionAction
+	err = json.Unmarshal(fields["actions"], &actions)
+	if err != nil {
+		return nil, err
+	}
+	missing := false
+	for _, action := range actions {
+		missing = missing || (!action.hasData() && len(action.HexData) > 0)
+	}
+	if !missing {
+		return trx, nil
+	}
+	err = decodeActionsData(client, history, actions, transactionActionSeqs(traces, actions), blockNum, indices)
+	if err != nil {
+		return nil, err
+	}
+	fields["actions"], err = json.Marshal(actions)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
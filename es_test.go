@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+
+//testIndices are three action_traces indices, the newest one is still being written
+var testIndices = []string{ "action_traces-1", "action_traces-2", "action_traces-3" }
+
+var testIndicesInfo = map[string]IndexInfo {
+	"action_traces-1": { Name: "action_traces-1", HasBlockRange: true, MinBlockNum: 1, MaxBlockNum: 100 },
+	"action_traces-2": { Name: "action_traces-2", HasBlockRange: true, MinBlockNum: 101, MaxBlockNum: 200 },
+	"action_traces-3": { Name: "action_traces-3", HasBlockRange: true, MinBlockNum: 201, MaxBlockNum: 250 },
+}
+
+
+func TestRouteIndices(t *testing.T) {
+	tests := []struct {
+		name        string
+		indices     []string
+		indicesInfo map[string]IndexInfo
+		blockNum    uint64
+		expected    []string
+	}{
+		{ "block zero", testIndices, testIndicesInfo, 0, testIndices },
+		{ "first index", testIndices, testIndicesInfo, 1, []string{ "action_traces-1" } },
+		{ "last block of index", testIndices, testIndicesInfo, 100, []string{ "action_traces-1" } },
+		{ "middle index", testIndices, testIndicesInfo, 150, []string{ "action_traces-2" } },
+		{ "newest index", testIndices, testIndicesInfo, 220, []string{ "action_traces-3" } },
+		{ "newest index is open ended", testIndices, testIndicesInfo, 1000, []string{ "action_traces-3" } },
+		{ "no indices", []string{}, testIndicesInfo, 10, []string{} },
+		{ "missing metadata", testIndices, map[string]IndexInfo{}, 10, testIndices },
+		{ "gap between indices", []string{ "action_traces-1", "action_traces-3" }, testIndicesInfo, 150, []string{ "action_traces-1", "action_traces-3" } },
+		{ "index without block range is skipped", testIndices, map[string]IndexInfo {
+			"action_traces-1": { Name: "action_traces-1" },
+			"action_traces-2": testIndicesInfo["action_traces-2"],
+			"action_traces-3": testIndicesInfo["action_traces-3"],
+		}, 150, []string{ "action_traces-2" } },
+	}
+	for _, test := range tests {
+		result := routeIndices(test.indices, test.indicesInfo, test.blockNum)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, result)
+		}
+	}
+}
+
+func TestIndicesFromBlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		indices     []string
+		indicesInfo map[string]IndexInfo
+		blockNum    uint64
+		expected    []string
+	}{
+		{ "from the beginning", testIndices, testIndicesInfo, 0, testIndices },
+		{ "last block of first index", testIndices, testIndicesInfo, 100, testIndices },
+		{ "after first index", testIndices, testIndicesInfo, 101, []string{ "action_traces-2", "action_traces-3" } },
+		{ "newest index only", testIndices, testIndicesInfo, 201, []string{ "action_traces-3" } },
+		{ "newest index is always included", testIndices, testIndicesInfo, 1000, []string{ "action_traces-3" } },
+		{ "missing metadata", testIndices, map[string]IndexInfo{}, 1000, testIndices },
+		{ "index without block range is included", testIndices, map[string]IndexInfo {
+			"action_traces-1": { Name: "action_traces-1" },
+			"action_traces-2": testIndicesInfo["action_traces-2"],
+			"action_traces-3": testIndicesInfo["action_traces-3"],
+		}, 201, []string{ "action_traces-1", "action_traces-3" } },
+		{ "no indices", []string{}, testIndicesInfo, 10, []string{} },
+	}
+	for _, test := range tests {
+		result := indicesFromBlock(test.indices, test.indicesInfo, test.blockNum)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, result)
+		}
+	}
+}
+
+
+func TestActionsCursorRoundTrip(t *testing.T) {
+	tests := []ActionsCursor {
+		{ Seq: 0, Order: OrderDesc },
+		{ Seq: 1, Order: OrderAsc },
+		{ Seq: 18446744073709551615, Order: OrderDesc },
+		{ Seq: 123456789, Order: OrderAsc, Backward: true },
+		{ Seq: 42, Order: OrderDesc, Backward: true, Filters: "abcdefghijkl" },
+	}
+	for _, test := range tests {
+		str := encodeActionsCursor(test)
+		cursor, err := decodeActionsCursor(str)
+		if err != nil {
+			t.Errorf("%+v: failed to decode %s: %v", test, str, err)
+			continue
+		}
+		if *cursor != test {
+			t.Errorf("expected %+v, got %+v", test, *cursor)
+		}
+	}
+}
+
+func TestDecodeActionsCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{ "empty", "" },
+		{ "not base64", "!!!" },
+		{ "not json", "bm90IGpzb24" },
+		{ "missing order", encodeActionsCursor(ActionsCursor{ Seq: 1 }) },
+		{ "unknown order", encodeActionsCursor(ActionsCursor{ Seq: 1, Order: "up" }) },
+		{ "padded base64", encodeActionsCursor(ActionsCursor{ Seq: 1, Order: OrderAsc }) + "=" },
+	}
+	for _, test := range tests {
+		if _, err := decodeActionsCursor(test.cursor); err == nil {
+			t.Errorf("%s: expected error for %q", test.name, test.cursor)
+		}
+	}
+}
+
+func TestValidateActionsParamsCursorFilters(t *testing.T) {
+	params := GetActionsParams{ AccountName: "eosio", Contract: "eosio.token", ActionName: "transfer" }
+	cursor := encodeActionsCursor(ActionsCursor{ Seq: 10, Order: OrderDesc, Filters: actionsCursorFilters(params) })
+	blockNum := uint64(100)
+	tests := []struct {
+		name   string
+		modify func(params *GetActionsParams)
+		valid  bool
+	}{
+		{ "same filters", func(params *GetActionsParams) {}, true },
+		{ "same filters with explicit match", func(params *GetActionsParams) { params.Match = MatchAny }, true },
+		{ "other limit and order", func(params *GetActionsParams) {
+			limit := int64(5)
+			params.Limit, params.Order = &limit, OrderAsc
+		}, true },
+		{ "other account", func(params *GetActionsParams) { params.AccountName = "eosio.token" }, false },
+		{ "other contract", func(params *GetActionsParams) { params.Contract = "" }, false },
+		{ "other action", func(params *GetActionsParams) { params.ActionName = "issue" }, false },
+		{ "other match", func(params *GetActionsParams) { params.Match = MatchReceiver }, false },
+		{ "other time range", func(params *GetActionsParams) { params.After = "2019-01-01T00:00:00" }, false },
+		{ "other block range", func(params *GetActionsParams) { params.BlockNumFrom = &blockNum }, false },
+	}
+	for _, test := range tests {
+		p := params
+		p.Cursor = &cursor
+		test.modify(&p)
+		err := validateActionsParams(p)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
@@ -5,6 +5,15 @@ import (
 )
 
 
+//IndexInfo holds metadata of ES index
+type IndexInfo struct {
+	Name          string
+	HasBlockRange bool
+	MinBlockNum   uint64
+	MaxBlockNum   uint64
+}
+
+
 type ActionTrace struct {
 	Receipt struct {
 		Receiver       json.RawMessage `json:"receiver"`
@@ -103,4 +112,65 @@ type Account struct {
 	} `json:"account_controls"`
 	Abi               json.RawMessage `json:"abi"`
 	AccountCreateTime json.RawMessage `json:"account_create_time"`
-}
\ No newline at end of file
+}
+
+
+type Block struct {
+	BlockNum     json.RawMessage `json:"block_num"`
+	BlockId               string `json:"block_id"`
+	Block map[string]json.RawMessage `json:"block"`
+	Irreversible json.RawMessage `json:"irreversible"`
+}
+
+
+type BlockTransaction struct {
+	Status        json.RawMessage `json:"status"`
+	CpuUsageUs    json.RawMessage `json:"cpu_usage_us"`
+	NetUsageWords json.RawMessage `json:"net_usage_words"`
+	Trx           json.RawMessage `json:"trx"`
+}
+
+//act.data of eosio::newaccount action
+type NewAccountData struct {
+	Creator    string `json:"creator"`
+	Name       string `json:"name"`
+	Owner   Authority `json:"owner"`
+	Active  Authority `json:"active"`
+}
+
+type UpdateAuthData struct {
+	Account    string `json:"account"`
+	Permission string `json:"permission"`
+	Parent     string `json:"parent"`
+	Auth    Authority `json:"auth"`
+}
+
+type DeleteAuthData struct {
+	Account    string `json:"account"`
+	Permission string `json:"permission"`
+}
+
+type Authority struct {
+	Threshold json.RawMessage `json:"threshold"`
+	Keys []struct {
+		Key             string `json:"key"`
+		Weight json.RawMessage `json:"weight"`
+	} `json:"keys"`
+	Accounts []struct {
+		Permission struct {
+			Actor      string `json:"actor"`
+			Permission string `json:"permission"`
+		} `json:"permission"`
+		Weight json.RawMessage `json:"weight"`
+	} `json:"accounts"`
+	Waits     json.RawMessage `json:"waits"`
+}
+
+
+//act.data of eosio::linkauth and eosio::unlinkauth actions
+type LinkAuthData struct {
+	Account     string `json:"account"`
+	Code        string `json:"code"`
+	Type        string `json:"type"`
+	Requirement string `json:"requirement"`
+}
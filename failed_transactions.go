@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultFailedTransactionsLimit int64 = 100
+const MaxFailedTransactionsLimit     int64 = 1000
+
+//FailedTransactionStatuses are receipt statuses of transactions which were not executed successfully
+var FailedTransactionStatuses = []string{ "hard_fail", "soft_fail", "expired" }
+
+//UnexecutedTransactionStatuses are statuses of transactions whose traces may contain no action traces:
+//expired transactions and hard failed deferred ones are not executed
+var UnexecutedTransactionStatuses = map[string]bool{ "hard_fail": true, "expired": true }
+
+//exceptionFormatParam matches ${name} placeholders in format of exception stack item
+var exceptionFormatParam = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+
+//parseException parses exception json of nodeos (fc::exception)
+//returns nil if except is empty
+func parseException(raw json.RawMessage) *TransactionException {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var except struct {
+		Code  json.RawMessage `json:"code"`
+		Name           string `json:"name"`
+		Message        string `json:"message"`
+		Stack []struct {
+			Format                     string `json:"format"`
+			Data   map[string]json.RawMessage `json:"data"`
+		} `json:"stack"`
+	}
+	if json.Unmarshal(raw, &except) != nil {
+		return nil
+	}
+	result := new(TransactionException)
+	code, _ := parseUint64(except.Code)
+	result.Code = int64(code)
+	result.Name = except.Name
+	result.Message = except.Message
+	result.Details = make([]string, 0, len(except.Stack))
+	for _, item := range except.Stack {
+		details := exceptionFormatParam.ReplaceAllStringFunc(item.Format, func(param string) string {
+			value, ok := item.Data[param[2:len(param) - 1]]
+			if !ok {
+				return param
+			}
+			var str string
+			if json.Unmarshal(value, &str) == nil {
+				return str
+			}
+			return string(value)
+		})
+		if len(details) > 0 {
+			result.Details = append(result.Details, details)
+		}
+	}
+	var stack struct {
+		Stack json.RawMessage `json:"stack"`
+	}
+	json.Unmarshal(raw, &stack)
+	result.Stack = stack.Stack
+	return result
+}
+
+//transactionException returns exception of transaction trace
+//or exception of the first failed action if the trace has no its own one
+func transactionException(trace *TransactionTrace) *TransactionException {
+	if except := parseException(trace.Except); except != nil {
+		return except
+	}
+	var search func(actionTraces []TransactionTraceActionTrace) *TransactionException
+	search = func(actionTraces []TransactionTraceActionTrace) *TransactionException {
+		for i, _ := range actionTraces {
+			if except := parseException(actionTraces[i].Except); except != nil {
+				return except
+			}
+			if except := search(actionTraces[i].InlineTraces); except != nil {
+				return except
+			}
+		}
+		return nil
+	}
+	return search(trace.ActionTraces)
+}
+
+
+//searchUnexecutedTransactions returns actions of failed transactions of account whose traces contain no action traces
+//by transaction id, such transactions are found by authorization of their actions in transactions indices,
+//up to MaxQuerySize traces without action traces are checked
+func searchUnexecutedTransactions(client *elastic.Client, account string, statuses []string, timeRange elastic.Query, indices map[string][]string) (map[string][]FailedTransactionAction, error) {
+	result := make(map[string][]FailedTransactionAction)
+	statusQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	unexecuted := false
+	for _, status := range statuses {
+		if UnexecutedTransactionStatuses[status] {
+			statusQuery = statusQuery.Should(elastic.NewMatchQuery("receipt.status", status))
+			unexecuted = true
+		}
+	}
+	if !unexecuted || len(indices[TransactionsIndexPrefix]) == 0 {
+		return result, nil
+	}
+	query := elastic.NewBoolQuery().
+		Filter(statusQuery).
+		MustNot(elastic.NewExistsQuery("action_traces.act.authorization.actor"))
+	if timeRange != nil {
+		query = query.Filter(timeRange)
+	}
+	tracesResult, err := client.Search(indices[TransactionTracesIndexPrefix]...).
+		Query(query).
+		FetchSource(false).
+		Size(MaxQuerySize).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if tracesResult == nil || tracesResult.Hits == nil {
+		return nil, errors.New("Empty ES response")
+	}
+	ids := make([]string, 0, len(tracesResult.Hits.Hits))
+	for _, hit := range tracesResult.Hits.Hits {
+		if hit != nil {
+			ids = append(ids, hit.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return result, nil
+	}
+	txsResult, err := client.Search(indices[TransactionsIndexPrefix]...).
+		Query(elastic.NewBoolQuery().
+			Filter(elastic.NewIdsQuery().Ids(ids...)).
+			Filter(elastic.NewMatchQuery("actions.authorization.actor", account))).
+		Size(len(ids)).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if txsResult == nil || txsResult.Hits == nil {
+		return nil, errors.New("Empty ES response")
+	}
+	for _, hit := range txsResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		var transaction Transaction
+		if json.Unmarshal(*hit.Source, &transaction) != nil {
+			return nil, errors.New("Failed to parse ES response")
+		}
+		actions := make([]FailedTransactionAction, 0)
+		json.Unmarshal(transaction.Actions, &actions)
+		result[hit.Id] = actions
+	}
+	return result, nil
+}
+
+//searchFailedTransactions returns hard failed, soft failed and expired transactions of account
+//transactions are searched in transaction_traces indices by authorization of their top level actions only:
+//these are the signers of the transaction, inline actions are authorized by contracts on their behalf
+//and are not executed for failed transactions anyway
+//traces of expired and hard failed deferred transactions have no action traces, they are matched by id
+//of transactions of account found by searchUnexecutedTransactions
+func searchFailedTransactions(client *elastic.Client, params SearchFailedTransactionsParams, indices map[string][]string) (*SearchFailedTransactionsResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name is required"))
+	}
+	statuses := FailedTransactionStatuses
+	if len(params.Status) > 0 {
+		statuses = nil
+		for _, status := range FailedTransactionStatuses {
+			if status == params.Status {
+				statuses = []string{ status }
+			}
+		}
+		if statuses == nil {
+			return nil, newErrorWithCode(400, errors.New("status must be one of: hard_fail, soft_fail, expired"))
+		}
+	}
+	var pos int64
+	if params.Pos != nil {
+		pos = *params.Pos
+	}
+	limit := DefaultFailedTransactionsLimit
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if pos < 0 || limit <= 0 || limit > MaxFailedTransactionsLimit {
+		return nil, newErrorWithCode(400, errors.New("pos must not be negative, limit must be between 1 and " + strconv.FormatInt(MaxFailedTransactionsLimit, 10)))
+	}
+	if pos + limit > int64(MaxQuerySize) {
+		return nil, newErrorWithCode(400, errors.New("Requested page is too deep, use narrower filters"))
+	}
+
+	result := new(SearchFailedTransactionsResult)
+	result.Transactions = make([]FailedTransaction, 0)
+	if len(indices[TransactionTracesIndexPrefix]) == 0 {
+		return result, nil
+	}
+	statusQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, status := range statuses {
+		statusQuery = statusQuery.Should(elastic.NewMatchQuery("receipt.status", status))
+	}
+	var timeRange elastic.Query
+	if len(params.After) > 0 || len(params.Before) > 0 {
+		rangeQuery := elastic.NewRangeQuery("block_time")
+		if len(params.After) > 0 {
+			rangeQuery = rangeQuery.Gte(params.After)
+		}
+		if len(params.Before) > 0 {
+			rangeQuery = rangeQuery.Lte(params.Before)
+		}
+		timeRange = rangeQuery
+	}
+	unexecuted, err := searchUnexecutedTransactions(client, params.AccountName, statuses, timeRange, indices)
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	accountQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1).
+		Should(elastic.NewMatchQuery("action_traces.act.authorization.actor", params.AccountName))
+	if len(unexecuted) > 0 {
+		ids := make([]string, 0, len(unexecuted))
+		for id, _ := range unexecuted {
+			ids = append(ids, id)
+		}
+		accountQuery = accountQuery.Should(elastic.NewIdsQuery().Ids(ids...))
+	}
+	query := elastic.NewBoolQuery().
+		Filter(statusQuery).
+		Filter(accountQuery)
+	if timeRange != nil {
+		query = query.Filter(timeRange)
+	}
+	searchResult, err := client.Search(indices[TransactionTracesIndexPrefix]...).
+		Query(query).
+		Sort("block_num", params.Order == OrderAsc).
+		//transactions of the same block are ordered by id so that pages don't overlap
+		Sort("id.keyword", params.Order == OrderAsc).
+		From(int(pos)).Size(int(limit)).
+		Do(context.Background())
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	if searchResult == nil || searchResult.Hits == nil {
+		return nil, newErrorWithCode(500, errors.New("Empty ES response"))
+	}
+	result.Total = searchResult.Hits.TotalHits
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		var txTrace TransactionTrace
+		err = json.Unmarshal(*hit.Source, &txTrace)
+		if err != nil {
+			return nil, newErrorWithCode(500, errors.New("Failed to parse ES response"))
+		}
+		transaction := FailedTransaction { Id: hit.Id, BlockNum: txTrace.BlockNum, BlockTime: txTrace.BlockTime,
+			Except: transactionException(&txTrace) }
+		json.Unmarshal(txTrace.Receipt["status"], &transaction.Status)
+		transaction.Actions = make([]FailedTransactionAction, 0, len(txTrace.ActionTraces))
+		for _, actionTrace := range txTrace.ActionTraces {
+			transaction.Actions = append(transaction.Actions, FailedTransactionAction { Account: actionTrace.Act.Account,
+				Name: actionTrace.Act.Name, Authorization: actionTrace.Act.Authorization })
+		}
+		if actions, ok := unexecuted[hit.Id]; ok && len(transaction.Actions) == 0 {
+			//actions of the transaction which was not executed
+			transaction.Actions = actions
+		}
+		result.Transactions = append(result.Transactions, transaction)
+	}
+	return result, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+	"golang.org/x/crypto/ripemd160"
+)
+
+
+const Base58Alphabet string = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+const KeyTypeK1 int = 0
+const KeyTypeR1 int = 1
+
+const PublicKeySize int = 33
+const SignatureSize int = 65
+
+
+//base58Encode encodes data with bitcoin base58 alphabet
+func base58Encode(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+	result := make([]byte, 0, len(data) * 138 / 100 + 1)
+	for value.Sign() > 0 {
+		value.DivMod(value, base, mod)
+		result = append(result, Base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		result = append(result, Base58Alphabet[0])
+	}
+	for i, j := 0, len(result) - 1; i < j; i, j = i + 1, j - 1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return string(result)
+}
+
+//keyChecksum returns the first 4 bytes of ripemd160 of data followed by suffix
+func keyChecksum(data []byte, suffix string) []byte {
+	hash := ripemd160.New()
+	hash.Write(data)
+	hash.Write([]byte(suffix))
+	return hash.Sum(nil)[:4]
+}
+
+//formatPublicKey formats binary public key the same way as nodeos does:
+//K1 keys in legacy "EOS..." format, R1 keys as "PUB_R1_..."
+func formatPublicKey(keyType int, key []byte) (string, error) {
+	if len(key) != PublicKeySize {
+		return "", errors.New("Invalid public key size")
+	}
+	switch keyType {
+	case KeyTypeK1:
+		return "EOS" + base58Encode(append(append([]byte{}, key...), keyChecksum(key, "")...)), nil
+	case KeyTypeR1:
+		return "PUB_R1_" + base58Encode(append(append([]byte{}, key...), keyChecksum(key, "R1")...)), nil
+	}
+	return "", errors.New("Unsupported public key type")
+}
+
+//formatSignature formats binary signature as "SIG_K1_..." or "SIG_R1_..."
+func formatSignature(sigType int, sig []byte) (string, error) {
+	if len(sig) != SignatureSize {
+		return "", errors.New("Invalid signature size")
+	}
+	switch sigType {
+	case KeyTypeK1:
+		return "SIG_K1_" + base58Encode(append(append([]byte{}, sig...), keyChecksum(sig, "K1")...)), nil
+	case KeyTypeR1:
+		return "SIG_R1_" + base58Encode(append(append([]byte{}, sig...), keyChecksum(sig, "R1")...)), nil
+	}
+	return "", errors.New("Unsupported signature type")
+}
+
+//base58Decode decodes string encoded with bitcoin base58 alphabet
+func base58Decode(s string) ([]byte, error) {
+	value := new(big.Int)
+	base := big.NewInt(58)
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(Base58Alphabet, s[i])
+		if digit < 0 {
+			return nil, errors.New("Invalid base58 character")
+		}
+		value.Mul(value, base)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == Base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), value.Bytes()...), nil
+}
+
+//parsePublicKey parses public key in legacy "EOS...", "PUB_K1_..." or "PUB_R1_..." format
+//and validates its checksum
+func parsePublicKey(s string) (int, []byte, error) {
+	var keyType int
+	var encoded, suffix string
+	switch {
+	case strings.HasPrefix(s, "PUB_K1_"):
+		keyType, encoded, suffix = KeyTypeK1, s[len("PUB_K1_"):], "K1"
+	case strings.HasPrefix(s, "PUB_R1_"):
+		keyType, encoded, suffix = KeyTypeR1, s[len("PUB_R1_"):], "R1"
+	case strings.HasPrefix(s, "EOS"):
+		keyType, encoded, suffix = KeyTypeK1, s[len("EOS"):], ""
+	default:
+		return 0, nil, errors.New("Unsupported public key format")
+	}
+	data, err := base58Decode(encoded)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) != PublicKeySize + 4 {
+		return 0, nil, errors.New("Invalid public key size")
+	}
+	key := data[:PublicKeySize]
+	if !bytes.Equal(keyChecksum(key, suffix), data[PublicKeySize:]) {
+		return 0, nil, errors.New("Invalid public key checksum")
+	}
+	return keyType, key, nil
+}
+
+//publicKeyFormats returns all string representations of public key,
+//the first one is the same as formatPublicKey() returns
+func publicKeyFormats(keyType int, key []byte) ([]string, error) {
+	legacy, err := formatPublicKey(keyType, key)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != KeyTypeK1 {
+		return []string{ legacy }, nil
+	}
+	k1 := "PUB_K1_" + base58Encode(append(append([]byte{}, key...), keyChecksum(key, "K1")...))
+	return []string{ legacy, k1 }, nil
+}
+
+//normalizePublicKey converts public key in any supported format to the format used by nodeos
+func normalizePublicKey(s string) (string, error) {
+	keyType, key, err := parsePublicKey(s)
+	if err != nil {
+		return "", err
+	}
+	return formatPublicKey(keyType, key)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+
+//testLegacyKey and testK1Key are the same well known development key in legacy and K1 formats
+const testLegacyKey string = "EOS6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW5CV"
+const testK1Key     string = "PUB_K1_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5BoDq63"
+
+
+//testR1Key returns R1 key formatted from fixed binary key
+func testR1Key(t *testing.T) (string, []byte) {
+	key := make([]byte, PublicKeySize)
+	key[0] = 0x02
+	for i := 1; i < len(key); i++ {
+		key[i] = byte(i * 7)
+	}
+	str, err := formatPublicKey(KeyTypeR1, key)
+	if err != nil {
+		t.Fatalf("failed to format R1 key: %v", err)
+	}
+	return str, key
+}
+
+func TestBase58RoundTrip(t *testing.T) {
+	tests := [][]byte {
+		{},
+		{ 0 },
+		{ 0, 0, 1 },
+		{ 1, 2, 3, 4, 5 },
+		{ 0xff, 0xff, 0xff, 0xff },
+	}
+	for _, test := range tests {
+		encoded := base58Encode(test)
+		decoded, err := base58Decode(encoded)
+		if err != nil {
+			t.Errorf("%x: unexpected error: %v", test, err)
+			continue
+		}
+		if !bytes.Equal(decoded, test) {
+			t.Errorf("%x: encoded as %q, decoded as %x", test, encoded, decoded)
+		}
+	}
+}
+
+func TestNormalizePublicKey(t *testing.T) {
+	r1, _ := testR1Key(t)
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{ "legacy", testLegacyKey, testLegacyKey },
+		{ "K1", testK1Key, testLegacyKey },
+		{ "R1", r1, r1 },
+	}
+	for _, test := range tests {
+		result, err := normalizePublicKey(test.key)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, result)
+		}
+	}
+}
+
+func TestPublicKeyRoundTrip(t *testing.T) {
+	r1, r1Binary := testR1Key(t)
+	_, k1Binary, err := parsePublicKey(testLegacyKey)
+	if err != nil {
+		t.Fatalf("failed to parse legacy key: %v", err)
+	}
+	tests := []struct {
+		name     string
+		keyType  int
+		key      []byte
+		expected []string
+	}{
+		{ "K1", KeyTypeK1, k1Binary, []string{ testLegacyKey, testK1Key } },
+		{ "R1", KeyTypeR1, r1Binary, []string{ r1 } },
+	}
+	for _, test := range tests {
+		formats, err := publicKeyFormats(test.keyType, test.key)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(formats) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, formats)
+			continue
+		}
+		for i, format := range formats {
+			if format != test.expected[i] {
+				t.Errorf("%s: expected %s, got %s", test.name, test.expected[i], format)
+			}
+			keyType, key, err := parsePublicKey(format)
+			if err != nil || keyType != test.keyType || !bytes.Equal(key, test.key) {
+				t.Errorf("%s: %s is not parsed back: %v", test.name, format, err)
+			}
+		}
+	}
+}
+
+func TestParsePublicKeyErrors(t *testing.T) {
+	r1, _ := testR1Key(t)
+	//changing the last character breaks the checksum but keeps the size
+	breakChecksum := func(key string) string {
+		last := key[len(key) - 1]
+		if last == '2' {
+			return key[:len(key) - 1] + "3"
+		}
+		return key[:len(key) - 1] + "2"
+	}
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{ "empty", "" },
+		{ "unknown prefix", "PUB_XX_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5BoDq63" },
+		{ "legacy bad checksum", breakChecksum(testLegacyKey) },
+		{ "K1 bad checksum", breakChecksum(testK1Key) },
+		{ "R1 bad checksum", breakChecksum(r1) },
+		{ "legacy checksum as K1", "PUB_K1_" + testLegacyKey[len("EOS"):] },
+		{ "K1 checksum as legacy", "EOS" + testK1Key[len("PUB_K1_"):] },
+		{ "K1 key as R1", "PUB_R1_" + testK1Key[len("PUB_K1_"):] },
+		{ "invalid base58 character", testLegacyKey[:10] + "0" + testLegacyKey[11:] },
+		{ "too short", testLegacyKey[:len(testLegacyKey) - 5] },
+	}
+	for _, test := range tests {
+		if _, _, err := parsePublicKey(test.key); err == nil {
+			t.Errorf("%s: expected error for %q", test.name, test.key)
+		}
+	}
+}
@@ -24,7 +24,13 @@ func main() {
 	}
 
 	var s Server
-	s.initElasticClient(config.ElasticUrl)
-	s.setRoutes()
+	s.initElasticClient(config.ElasticUrl, config.IndexPattern, config.ActionCountCacheSize)
+	s.initNodePool(config.NodeUrls, config.NodeMaxBlockLag, config.NodeHealthCheckIntervalSeconds)
+	s.initChainInfoPoller(config.ChainInfoPollIntervalMs, config.ChainInfoMaxAgeMs)
+	s.initActionTailer(config.StreamPollIntervalMs)
+	s.initWebhooks(config.WebhooksFile)
+	s.setRoutes(config.StreamAllowedOrigins)
+	s.setV2Routes()
+	s.setAdminRoutes(config.AdminToken)
 	s.listen(config.Port)
 }
\ No newline at end of file
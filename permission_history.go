@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultPermissionHistoryCacheSize  int = 1000
+const PermissionHistoryRefreshSeconds   int64 = 10
+const PermissionHistoryBatchSize          int = 1000
+const PermissionHistoryRescanWindow    uint64 = 100000
+const MaxPermissionHistoryChanges         int = 10000
+
+
+//permissionHistoryEntry holds all permission changes of one account in ascending order
+type permissionHistoryEntry struct {
+	Changes   []PermissionChange
+	MaxSeq    uint64
+	CheckedAt time.Time
+}
+
+//PermissionHistory reconstructs permission changes of accounts from eosio
+//newaccount, updateauth, deleteauth, linkauth and unlinkauth actions
+//changes are cached per account and refreshed incrementally: only actions
+//after the last replayed one minus PermissionHistoryRescanWindow are requested
+type PermissionHistory struct {
+	Search     ActionsSearch
+	GetIndices func() map[string][]string
+	Abis       *AbiHistory
+	Entries    map[string]*permissionHistoryEntry
+	MaxSize    int
+	Mutex      sync.Mutex
+}
+
+
+func newPermissionHistory(client *elastic.Client, getIndices func() map[string][]string, abis *AbiHistory, maxSize int) *PermissionHistory {
+	if maxSize <= 0 {
+		maxSize = DefaultPermissionHistoryCacheSize
+	}
+	history := new(PermissionHistory)
+	history.Search = newActionsSearch(client)
+	history.GetIndices = getIndices
+	history.Abis = abis
+	history.Entries = make(map[string]*permissionHistoryEntry)
+	history.MaxSize = maxSize
+	return history
+}
+
+//changes returns all known permission changes of account in ascending order
+func (h *PermissionHistory) changes(account string) ([]PermissionChange, error) {
+	h.Mutex.Lock()
+	entry, ok := h.Entries[account]
+	if ok && time.Since(entry.CheckedAt) < time.Duration(PermissionHistoryRefreshSeconds) * time.Second {
+		h.Mutex.Unlock()
+		return entry.Changes, nil
+	}
+	h.Mutex.Unlock()
+	if !ok {
+		entry = &permissionHistoryEntry { Changes: []PermissionChange{} }
+	}
+
+	updated, err := replayPermissionChanges(h.Search, h.GetIndices()[ActionTracesIndexPrefix], h.Abis, account, entry)
+	if err != nil && ok {
+		//keep using outdated changes
+		return entry.Changes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+	if current, ok := h.Entries[account]; ok && current.MaxSeq > updated.MaxSeq {
+		//refreshed concurrently
+		return current.Changes, nil
+	}
+	if _, ok := h.Entries[account]; !ok && len(h.Entries) >= h.MaxSize {
+		//evict an arbitrary entry
+		for k, _ := range h.Entries {
+			delete(h.Entries, k)
+			break
+		}
+	}
+	h.Entries[account] = updated
+	return updated.Changes, nil
+}
+
+//replayPermissionChanges returns entry extended with changes of actions after entry.MaxSeq
+//actions may be indexed out of order, so changes of the last PermissionHistoryRescanWindow sequences are replayed again
+//entry may be shared with readers and is not modified
+func replayPermissionChanges(search ActionsSearch, indices []string, abis *AbiHistory, account string, entry *permissionHistoryEntry) (*permissionHistoryEntry, error) {
+	//newaccount is authorized by the creator, so it is matched by the new account name only
+	newAccountQuery := newSystemActionQuery("newaccount").
+		Filter(elastic.NewMatchQuery("act.data.name", account))
+	//data of other actions may be not indexed, they are always authorized by the account itself
+	authQuery := newSystemActionQuery("updateauth", "deleteauth", "linkauth", "unlinkauth").
+		Filter(elastic.NewBoolQuery().MinimumNumberShouldMatch(1).
+			Should(elastic.NewMatchQuery("act.data.account", account)).
+			Should(elastic.NewMatchQuery("act.authorization.actor", account)))
+	query := elastic.NewBoolQuery().MinimumNumberShouldMatch(1).Should(newAccountQuery, authQuery)
+
+	var afterSeq uint64
+	if entry.MaxSeq > PermissionHistoryRescanWindow {
+		afterSeq = entry.MaxSeq - PermissionHistoryRescanWindow
+	}
+	kept := sort.Search(len(entry.Changes), func(i int) bool {
+		return entry.Changes[i].GlobalActionSeq > afterSeq
+	})
+	//changes slice may be shared with readers, never append to it in place
+	changes := append(make([]PermissionChange, 0, kept), entry.Changes[:kept]...)
+	result := &permissionHistoryEntry { MaxSeq: entry.MaxSeq, CheckedAt: time.Now() }
+	for {
+		page, err := search(indices, query, afterSeq, PermissionHistoryBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		afterSeq = page.LastSeq
+		for _, action := range page.Actions {
+			actionBlockNum, _ := parseUint64(action.Trace.BlockNum)
+			changes = append(changes, parsePermissionChanges(abis, action, actionBlockNum, account)...)
+		}
+		if page.Hits < PermissionHistoryBatchSize {
+			break
+		}
+	}
+	if afterSeq > result.MaxSeq {
+		result.MaxSeq = afterSeq
+	}
+	result.Changes = changes
+	return result, nil
+}
+
+//getPermissionHistory returns permission changes of account in chronological order
+//and permissions with links which were effective at given block (the latest ones if block is not set)
+func getPermissionHistory(history *PermissionHistory, params GetPermissionHistoryParams) (*GetPermissionHistoryResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name is required"))
+	}
+	blockNum := uint64(math.MaxUint64)
+	if params.BlockNum != nil {
+		blockNum = *params.BlockNum
+	}
+	changes, err := history.changes(params.AccountName)
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	//all changes up to the block are applied, only the returned list is truncated
+	end := sort.Search(len(changes), func(i int) bool {
+		return changes[i].BlockNum > blockNum
+	})
+	changes = changes[:end]
+
+	result := new(GetPermissionHistoryResult)
+	result.AccountName = params.AccountName
+	result.Permissions, result.Links = applyPermissionChanges(changes)
+	result.Changes = changes
+	if len(changes) > MaxPermissionHistoryChanges {
+		result.Changes = changes[:MaxPermissionHistoryChanges]
+		result.Truncated = true
+	}
+	return result, nil
+}
+
+//parsePermissionChanges converts action into changes of account permissions
+//newaccount creates two changes for owner and active permissions,
+//actions of other accounts and actions which data can't be parsed are skipped
+func parsePermissionChanges(history *AbiHistory, action TailedAction, blockNum uint64, account string) []PermissionChange {
+	var name string
+	json.Unmarshal(action.Trace.Act.Name, &name)
+	data, err := permissionActionData(history, action, name, blockNum)
+	if err != nil {
+		return nil
+	}
+	change := PermissionChange { Action: name, BlockNum: blockNum, BlockTime: action.Trace.BlockTime,
+		TrxId: action.Trace.TrxId, GlobalActionSeq: action.Seq }
+	switch name {
+	case "newaccount":
+		var newAccount NewAccountData
+		if json.Unmarshal(data, &newAccount) != nil || newAccount.Name != account {
+			return nil
+		}
+		owner, active := change, change
+		owner.Permission, owner.Auth = "owner", &newAccount.Owner
+		active.Permission, active.Parent, active.Auth = "active", "owner", &newAccount.Active
+		return []PermissionChange{ owner, active }
+	case "updateauth":
+		var updateAuth UpdateAuthData
+		if json.Unmarshal(data, &updateAuth) != nil || updateAuth.Account != account {
+			return nil
+		}
+		change.Permission, change.Parent, change.Auth = updateAuth.Permission, updateAuth.Parent, &updateAuth.Auth
+	case "deleteauth":
+		var deleteAuth DeleteAuthData
+		if json.Unmarshal(data, &deleteAuth) != nil || deleteAuth.Account != account {
+			return nil
+		}
+		change.Permission = deleteAuth.Permission
+	case "linkauth", "unlinkauth":
+		var link struct {
+			Account     string `json:"account"`
+			Code        string `json:"code"`
+			Type        string `json:"type"`
+			Requirement string `json:"requirement"`
+		}
+		if json.Unmarshal(data, &link) != nil || link.Account != account {
+			return nil
+		}
+		change.Code, change.Type, change.Requirement = link.Code, link.Type, link.Requirement
+	default:
+		return nil
+	}
+	return []PermissionChange{ change }
+}
+
+//permissionActionData returns json data of action,
+//hex_data is decoded with eosio abi active at the action if json data is not indexed
+func permissionActionData(history *AbiHistory, action TailedAction, name string, blockNum uint64) (json.RawMessage, error) {
+	data := action.Trace.Act.Data
+	if len(data) > 0 && data[0] == '{' {
+		return data, nil
+	}
+	var hexData string
+	json.Unmarshal(action.Trace.Act.HexData, &hexData)
+	binary, err := hex.DecodeString(hexData)
+	if err != nil || len(binary) == 0 {
+		return nil, errors.New("Action data is not indexed")
+	}
+	version, err := history.abiAt("eosio", blockNum, action.Seq)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil || version.Abi == nil {
+		return nil, errors.New("Abi of eosio is not found")
+	}
+	return version.Abi.decodeActionData(name, binary)
+}
+
+//applyPermissionChanges returns permissions and links of account after all changes
+func applyPermissionChanges(changes []PermissionChange) ([]EffectivePermission, []PermissionLink) {
+	permissions := make(map[string]EffectivePermission)
+	links := make(map[string]PermissionLink)
+	for _, change := range changes {
+		switch change.Action {
+		case "newaccount", "updateauth":
+			permissions[change.Permission] = EffectivePermission { Permission: change.Permission,
+				Parent: change.Parent, Auth: change.Auth, UpdatedBlockNum: change.BlockNum }
+		case "deleteauth":
+			delete(permissions, change.Permission)
+		case "linkauth":
+			links[change.Code + ":" + change.Type] = PermissionLink { Code: change.Code, Type: change.Type,
+				Requirement: change.Requirement, UpdatedBlockNum: change.BlockNum }
+		case "unlinkauth":
+			delete(links, change.Code + ":" + change.Type)
+		}
+	}
+	permissionsResult := make([]EffectivePermission, 0, len(permissions))
+	for _, permission := range permissions {
+		permissionsResult = append(permissionsResult, permission)
+	}
+	sort.Slice(permissionsResult, func(i, j int) bool {
+		return permissionsResult[i].Permission < permissionsResult[j].Permission
+	})
+	linksResult := make([]PermissionLink, 0, len(links))
+	for _, link := range links {
+		linksResult = append(linksResult, link)
+	}
+	sort.Slice(linksResult, func(i, j int) bool {
+		if linksResult[i].Code != linksResult[j].Code {
+			return linksResult[i].Code < linksResult[j].Code
+		}
+		return linksResult[i].Type < linksResult[j].Type
+	})
+	return permissionsResult, linksResult
+}
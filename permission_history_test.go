@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"github.com/olivere/elastic"
+)
+
+
+//testTrace returns action trace with given global sequence and block number
+//act is json of the action: account, name, authorization and data
+func testTrace(t *testing.T, seq uint64, blockNum uint64, act string) TailedAction {
+	trace := new(ActionTrace)
+	str := fmt.Sprintf(`{ "receipt": { "global_sequence": %d }, "block_num": %d, "trx_id": "trx%d", "act": %s }`,
+		seq, blockNum, seq, act)
+	if err := json.Unmarshal([]byte(str), trace); err != nil {
+		t.Fatalf("invalid test trace %s: %v", str, err)
+	}
+	return TailedAction{ Seq: seq, Trace: trace }
+}
+
+//testActionsSearch returns ActionsSearch which pages actions ignoring indices and query
+//actions with nil Trace are hits which can't be parsed
+func testActionsSearch(actions []TailedAction) ActionsSearch {
+	return func(indices []string, query elastic.Query, afterSeq uint64, size int) (*ActionsPage, error) {
+		page := &ActionsPage{ Actions: make([]TailedAction, 0), LastSeq: afterSeq }
+		for _, action := range actions {
+			if action.Seq <= afterSeq || page.Hits >= size {
+				continue
+			}
+			page.Hits++
+			page.LastSeq = action.Seq
+			if action.Trace != nil {
+				page.Actions = append(page.Actions, action)
+			}
+		}
+		return page, nil
+	}
+}
+
+func testAuthority(key string) *Authority {
+	authority := new(Authority)
+	json.Unmarshal([]byte(`{ "threshold": 1, "keys": [ { "key": "` + key + `", "weight": 1 } ] }`), authority)
+	return authority
+}
+
+
+func TestApplyPermissionChanges(t *testing.T) {
+	owner, active, trading := testAuthority("OWNER"), testAuthority("ACTIVE"), testAuthority("TRADING")
+	created := []PermissionChange {
+		{ Action: "newaccount", Permission: "owner", Auth: owner, BlockNum: 1 },
+		{ Action: "newaccount", Permission: "active", Parent: "owner", Auth: active, BlockNum: 1 },
+	}
+	tests := []struct {
+		name        string
+		changes     []PermissionChange
+		permissions []EffectivePermission
+		links       []PermissionLink
+	}{
+		{ "no changes", []PermissionChange{}, []EffectivePermission{}, []PermissionLink{} },
+		{ "newaccount", created, []EffectivePermission {
+			{ Permission: "active", Parent: "owner", Auth: active, UpdatedBlockNum: 1 },
+			{ Permission: "owner", Auth: owner, UpdatedBlockNum: 1 },
+		}, []PermissionLink{} },
+		{ "updateauth replaces and adds permissions", append(created[:2:2],
+			PermissionChange{ Action: "updateauth", Permission: "active", Parent: "owner", Auth: owner, BlockNum: 5 },
+			PermissionChange{ Action: "updateauth", Permission: "trading", Parent: "active", Auth: trading, BlockNum: 6 },
+		), []EffectivePermission {
+			{ Permission: "active", Parent: "owner", Auth: owner, UpdatedBlockNum: 5 },
+			{ Permission: "owner", Auth: owner, UpdatedBlockNum: 1 },
+			{ Permission: "trading", Parent: "active", Auth: trading, UpdatedBlockNum: 6 },
+		}, []PermissionLink{} },
+		{ "deleteauth", append(created[:2:2],
+			PermissionChange{ Action: "updateauth", Permission: "trading", Parent: "active", Auth: trading, BlockNum: 6 },
+			PermissionChange{ Action: "deleteauth", Permission: "trading", BlockNum: 7 },
+		), []EffectivePermission {
+			{ Permission: "active", Parent: "owner", Auth: active, UpdatedBlockNum: 1 },
+			{ Permission: "owner", Auth: owner, UpdatedBlockNum: 1 },
+		}, []PermissionLink{} },
+		{ "linkauth and unlinkauth", []PermissionChange {
+			{ Action: "linkauth", Code: "eosio.token", Type: "transfer", Requirement: "trading", BlockNum: 2 },
+			{ Action: "linkauth", Code: "eosio", Type: "buyram", Requirement: "trading", BlockNum: 3 },
+			{ Action: "linkauth", Code: "eosio.token", Type: "transfer", Requirement: "active", BlockNum: 4 },
+			{ Action: "linkauth", Code: "eosio", Type: "sellram", Requirement: "trading", BlockNum: 5 },
+			{ Action: "unlinkauth", Code: "eosio", Type: "sellram", BlockNum: 6 },
+		}, []EffectivePermission{}, []PermissionLink {
+			{ Code: "eosio", Type: "buyram", Requirement: "trading", UpdatedBlockNum: 3 },
+			{ Code: "eosio.token", Type: "transfer", Requirement: "active", UpdatedBlockNum: 4 },
+		} },
+	}
+	for _, test := range tests {
+		permissions, links := applyPermissionChanges(test.changes)
+		if !reflect.DeepEqual(permissions, test.permissions) {
+			t.Errorf("%s: expected permissions %+v, got %+v", test.name, test.permissions, permissions)
+		}
+		if !reflect.DeepEqual(links, test.links) {
+			t.Errorf("%s: expected links %+v, got %+v", test.name, test.links, links)
+		}
+	}
+}
+
+func TestReplayPermissionChanges(t *testing.T) {
+	auth := `{ "threshold": 1, "keys": [], "accounts": [] }`
+	actions := []TailedAction {
+		testTrace(t, 10, 1, `{ "account": "eosio", "name": "newaccount",
+			"data": { "creator": "eosio", "name": "alice", "owner": ` + auth + `, "active": ` + auth + ` } }`),
+		//action of another account found by the analyzed match query
+		testTrace(t, 20, 2, `{ "account": "eosio", "name": "updateauth",
+			"data": { "account": "bob", "permission": "active", "parent": "owner", "auth": ` + auth + ` } }`),
+		{ Seq: 30 },
+		testTrace(t, 40, 4, `{ "account": "eosio", "name": "updateauth",
+			"data": { "account": "alice", "permission": "trading", "parent": "active", "auth": ` + auth + ` } }`),
+		testTrace(t, 50, 5, `{ "account": "eosio", "name": "linkauth",
+			"data": { "account": "alice", "code": "eosio.token", "type": "transfer", "requirement": "trading" } }`),
+	}
+	history := newAbiHistory(nil, nil, 0)
+	entry := &permissionHistoryEntry { Changes: []PermissionChange{} }
+
+	result, err := replayPermissionChanges(testActionsSearch(actions), nil, history, "alice", entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []struct {
+		action     string
+		permission string
+		seq        uint64
+	}{
+		{ "newaccount", "owner", 10 },
+		{ "newaccount", "active", 10 },
+		{ "updateauth", "trading", 40 },
+		{ "linkauth", "", 50 },
+	}
+	if len(result.Changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), result.Changes)
+	}
+	for i, change := range result.Changes {
+		if change.Action != expected[i].action || change.Permission != expected[i].permission ||
+			change.GlobalActionSeq != expected[i].seq {
+			t.Errorf("change %d: expected %+v, got %+v", i, expected[i], change)
+		}
+	}
+	if result.MaxSeq != 50 {
+		t.Errorf("expected max seq 50, got %d", result.MaxSeq)
+	}
+	if len(entry.Changes) != 0 {
+		t.Errorf("replay modified the cached entry: %+v", entry.Changes)
+	}
+
+	//actions within the rescan window are replayed again instead of being duplicated
+	actions = append(actions, testTrace(t, 60, 6, `{ "account": "eosio", "name": "deleteauth",
+		"data": { "account": "alice", "permission": "trading" } }`))
+	refreshed, err := replayPermissionChanges(testActionsSearch(actions), nil, history, "alice", result)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(refreshed.Changes) != len(expected) + 1 || refreshed.Changes[len(expected)].Action != "deleteauth" {
+		t.Errorf("expected deleteauth appended once, got %+v", refreshed.Changes)
+	}
+	if refreshed.MaxSeq != 60 {
+		t.Errorf("expected max seq 60, got %d", refreshed.MaxSeq)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultRamDeltasLimit       int64 = 100
+const MaxRamDeltasLimit           int64 = 1000
+const DefaultRamTotalsCacheSize     int = 1000
+const RamTotalsRefreshSeconds     int64 = 10
+const RamTotalsBatchSize            int = 1000
+const RamTotalsRescanWindow      uint64 = 100000
+const RamTotalsWaitSeconds        int64 = 10
+
+
+//accountRamDelta is an item of account_ram_deltas of action trace
+type accountRamDelta struct {
+	Account         string `json:"account"`
+	Delta  json.RawMessage `json:"delta"`
+}
+
+//ramDeltaOf returns sum of RAM deltas of account in action trace
+func ramDeltaOf(trace *ActionTrace, account string) (int64, bool) {
+	var deltas []accountRamDelta
+	if json.Unmarshal(trace.AccountRamDeltas, &deltas) != nil {
+		return 0, false
+	}
+	var sum int64
+	found := false
+	for _, delta := range deltas {
+		if delta.Account != account {
+			continue
+		}
+		var value json.Number
+		if json.Unmarshal(delta.Delta, &value) != nil {
+			//delta may be indexed as a string
+			var str string
+			if json.Unmarshal(delta.Delta, &str) != nil {
+				continue
+			}
+			value = json.Number(str)
+		}
+		v, err := value.Int64()
+		if err != nil {
+			continue
+		}
+		sum += v
+		found = true
+	}
+	return sum, found
+}
+
+//ramCheckpoint is the sum of RAM deltas of account in actions with global sequence up to Seq
+type ramCheckpoint struct {
+	Seq   uint64
+	Total int64
+}
+
+//ramTotalsEntry holds checkpoints of one account in ascending order,
+//one per RamTotalsBatchSize actions and one at the last replayed action
+type ramTotalsEntry struct {
+	Checkpoints []ramCheckpoint
+	CheckedAt   time.Time
+}
+
+//ramReplay is a replay of RAM deltas of one account running in background
+type ramReplay struct {
+	Done        chan struct{}
+	Checkpoints []ramCheckpoint
+	Err         error
+}
+
+//RamTotals caches running totals of account RAM deltas as checkpoints
+//so that the sum before any action is computed from the nearest checkpoint by reading at most one batch of actions
+//checkpoints are refreshed incrementally, the last RamTotalsRescanWindow sequences are replayed again
+//to take actions indexed out of order into account
+//only one replay of an account runs at a time
+type RamTotals struct {
+	Search     ActionsSearch
+	GetIndices func() map[string][]string
+	Entries    map[string]*ramTotalsEntry
+	Replays    map[string]*ramReplay
+	MaxSize    int
+	Mutex      sync.Mutex
+}
+
+var errRamTotalsReplaying = errors.New("RAM totals are being replayed, try again later")
+
+
+func newRamTotals(client *elastic.Client, getIndices func() map[string][]string, maxSize int) *RamTotals {
+	if maxSize <= 0 {
+		maxSize = DefaultRamTotalsCacheSize
+	}
+	totals := new(RamTotals)
+	totals.Search = newActionsSearch(client)
+	totals.GetIndices = getIndices
+	totals.Entries = make(map[string]*ramTotalsEntry)
+	totals.Replays = make(map[string]*ramReplay)
+	totals.MaxSize = maxSize
+	return totals
+}
+
+func newRamDeltasQuery(account string) elastic.Query {
+	return elastic.NewBoolQuery().Filter(elastic.NewMatchQuery("account_ram_deltas.account", account))
+}
+
+//checkpoints returns checkpoints of account, replaying new actions if the cached ones are outdated
+//the first replay runs in background, errRamTotalsReplaying is returned if it takes longer than RamTotalsWaitSeconds
+//outdated checkpoints are returned while another request refreshes them
+func (r *RamTotals) checkpoints(account string) ([]ramCheckpoint, error) {
+	r.Mutex.Lock()
+	entry, ok := r.Entries[account]
+	if ok && time.Since(entry.CheckedAt) < time.Duration(RamTotalsRefreshSeconds) * time.Second {
+		r.Mutex.Unlock()
+		return entry.Checkpoints, nil
+	}
+	replay, running := r.Replays[account]
+	if ok && running {
+		r.Mutex.Unlock()
+		return entry.Checkpoints, nil
+	}
+	if !running {
+		replay = &ramReplay{ Done: make(chan struct{}) }
+		r.Replays[account] = replay
+	}
+	r.Mutex.Unlock()
+
+	if ok {
+		r.refresh(account, entry.Checkpoints, replay)
+		if replay.Err != nil {
+			//keep using outdated checkpoints
+			return entry.Checkpoints, nil
+		}
+		return replay.Checkpoints, nil
+	}
+	if !running {
+		go r.refresh(account, nil, replay)
+	}
+	select {
+	case <-replay.Done:
+	case <-time.After(time.Duration(RamTotalsWaitSeconds) * time.Second):
+		return nil, errRamTotalsReplaying
+	}
+	return replay.Checkpoints, replay.Err
+}
+
+//refresh replays actions after checkpoints, stores the result and finishes replay
+func (r *RamTotals) refresh(account string, checkpoints []ramCheckpoint, replay *ramReplay) {
+	defer close(replay.Done)
+	replay.Checkpoints, replay.Err = replayRamDeltas(r.Search, r.GetIndices()[ActionTracesIndexPrefix], account, checkpoints)
+
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	delete(r.Replays, account)
+	if replay.Err != nil {
+		return
+	}
+	if _, ok := r.Entries[account]; !ok && len(r.Entries) >= r.MaxSize {
+		//evict an arbitrary entry
+		for k, _ := range r.Entries {
+			delete(r.Entries, k)
+			break
+		}
+	}
+	r.Entries[account] = &ramTotalsEntry { Checkpoints: replay.Checkpoints, CheckedAt: time.Now() }
+}
+
+//replayRamDeltas returns checkpoints extended with actions after the last checkpoint minus RamTotalsRescanWindow
+//checkpoints slice may be shared with readers and is not modified
+func replayRamDeltas(search ActionsSearch, indices []string, account string, checkpoints []ramCheckpoint) ([]ramCheckpoint, error) {
+	var from ramCheckpoint
+	kept := 0
+	if len(checkpoints) > 0 {
+		last := checkpoints[len(checkpoints) - 1].Seq
+		var rescanFrom uint64
+		if last > RamTotalsRescanWindow {
+			rescanFrom = last - RamTotalsRescanWindow
+		}
+		kept = sort.Search(len(checkpoints), func(i int) bool {
+			return checkpoints[i].Seq > rescanFrom
+		})
+		if kept > 0 {
+			from = checkpoints[kept - 1]
+		}
+	}
+	result := append(make([]ramCheckpoint, 0, kept + 1), checkpoints[:kept]...)
+	current := from
+	for {
+		page, err := search(indices, newRamDeltasQuery(account), current.Seq, RamTotalsBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, action := range page.Actions {
+			delta, _ := ramDeltaOf(action.Trace, account)
+			current.Total += delta
+		}
+		current.Seq = page.LastSeq
+		if page.Hits < RamTotalsBatchSize {
+			break
+		}
+		result = append(result, current)
+	}
+	if current.Seq > from.Seq && (len(result) == 0 || result[len(result) - 1].Seq != current.Seq) {
+		result = append(result, current)
+	}
+	return result, nil
+}
+
+//sumBefore returns sum of RAM deltas of account in actions with global sequence less than seq
+//actions between the nearest checkpoint and seq are summed from their _source
+func (r *RamTotals) sumBefore(account string, seq uint64) (int64, error) {
+	checkpoints, err := r.checkpoints(account)
+	if err != nil {
+		return 0, err
+	}
+	i := sort.Search(len(checkpoints), func(i int) bool {
+		return checkpoints[i].Seq >= seq
+	})
+	var current ramCheckpoint
+	if i > 0 {
+		current = checkpoints[i - 1]
+	}
+	indices := r.GetIndices()[ActionTracesIndexPrefix]
+	for {
+		page, err := r.Search(indices, newRamDeltasQuery(account), current.Seq, RamTotalsBatchSize)
+		if err != nil {
+			return 0, err
+		}
+		for _, action := range page.Actions {
+			if action.Seq >= seq {
+				return current.Total, nil
+			}
+			delta, _ := ramDeltaOf(action.Trace, account)
+			current.Total += delta
+		}
+		current.Seq = page.LastSeq
+		if page.Hits < RamTotalsBatchSize || current.Seq >= seq {
+			return current.Total, nil
+		}
+	}
+}
+
+
+//getRamDeltas returns page of RAM changes of account ordered by global sequence
+//running total is RAM delta of the account summed from the first indexed action up to the row,
+//the sum before the page is taken from cached checkpoints of totals
+func getRamDeltas(client *elastic.Client, totals *RamTotals, params GetRamDeltasParams, indices map[string][]string) (*GetRamDeltasResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name is required"))
+	}
+	limit := DefaultRamDeltasLimit
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if limit <= 0 || limit > MaxRamDeltasLimit {
+		return nil, newErrorWithCode(400, errors.New("limit must be between 1 and " + strconv.FormatInt(MaxRamDeltasLimit, 10)))
+	}
+	cursor := &ActionsCursor{ Order: OrderDesc }
+	if params.Order == OrderAsc {
+		cursor.Order = OrderAsc
+	}
+	firstPage := true
+	if len(params.Cursor) > 0 {
+		var err error
+		cursor, err = decodeActionsCursor(params.Cursor)
+		if err != nil || cursor.Backward {
+			return nil, newErrorWithCode(400, errors.New("Invalid cursor"))
+		}
+		firstPage = false
+	}
+	ascOrder := cursor.Order == OrderAsc
+
+	result := new(GetRamDeltasResult)
+	result.AccountName = params.AccountName
+	result.Deltas = make([]RamDelta, 0)
+	if len(indices[ActionTracesIndexPrefix]) == 0 {
+		return result, nil
+	}
+	search := client.Search(indices[ActionTracesIndexPrefix]...).
+		Query(newRamDeltasQuery(params.AccountName)).
+		Sort("receipt.global_sequence", ascOrder).
+		Size(int(limit) + 1)
+	if !firstPage {
+		search = search.SearchAfter(cursor.Seq)
+	}
+	searchResult, err := search.Do(context.Background())
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	if searchResult == nil || searchResult.Hits == nil {
+		return nil, newErrorWithCode(500, errors.New("Empty ES response"))
+	}
+	hits := searchResult.Hits.Hits
+	if int64(len(hits)) > limit {
+		hits = hits[:limit]
+	}
+	for _, hit := range hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		var actionTrace ActionTrace
+		err = json.Unmarshal(*hit.Source, &actionTrace)
+		if err != nil {
+			return nil, newErrorWithCode(500, errors.New("Failed to parse ES response"))
+		}
+		seq, err := parseUint64(actionTrace.Receipt.GlobalSequence)
+		if err != nil {
+			continue
+		}
+		cursor.Seq = seq
+		delta, ok := ramDeltaOf(&actionTrace, params.AccountName)
+		if !ok {
+			continue
+		}
+		row := RamDelta { GlobalActionSeq: seq, BlockNum: actionTrace.BlockNum, BlockTime: actionTrace.BlockTime,
+			TrxId: actionTrace.TrxId, Delta: delta }
+		json.Unmarshal(actionTrace.Act.Account, &row.Contract)
+		json.Unmarshal(actionTrace.Act.Name, &row.Action)
+		json.Unmarshal(actionTrace.Receipt.Receiver, &row.Receiver)
+		result.Deltas = append(result.Deltas, row)
+	}
+	if int64(len(searchResult.Hits.Hits)) > limit {
+		result.NextCursor = encodeActionsCursor(*cursor)
+	}
+	if len(result.Deltas) == 0 {
+		return result, nil
+	}
+
+	//running totals are accumulated in ascending order
+	first := 0
+	if !ascOrder {
+		first = len(result.Deltas) - 1
+	}
+	total, err := totals.sumBefore(params.AccountName, result.Deltas[first].GlobalActionSeq)
+	if err == errRamTotalsReplaying {
+		return nil, newErrorWithCode(503, err)
+	}
+	if err != nil {
+		return nil, newErrorWithCode(500, err)
+	}
+	for i, _ := range result.Deltas {
+		j := i
+		if !ascOrder {
+			j = len(result.Deltas) - 1 - i
+		}
+		total += result.Deltas[j].Delta
+		result.Deltas[j].RunningTotal = total
+	}
+	return result, nil
+}
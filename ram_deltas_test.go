@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+//testRamAction returns action trace with RAM delta of account
+func testRamAction(t *testing.T, seq uint64, account string, delta int64) TailedAction {
+	action := testTrace(t, seq, seq, `{ "account": "eosio", "name": "buyram" }`)
+	action.Trace.AccountRamDeltas = json.RawMessage(fmt.Sprintf(`[ { "account": "%s", "delta": %d } ]`, account, delta))
+	return action
+}
+
+//testRamActions returns count actions with even sequences starting from start,
+//every action adds 1 byte to alice, every 10th one is an action of bob and
+//the 7th one can't be parsed
+func testRamActions(t *testing.T, start uint64, count int) []TailedAction {
+	actions := make([]TailedAction, 0, count)
+	for i := 1; i <= count; i++ {
+		seq := start + 2 * uint64(i)
+		switch {
+		case i == 7:
+			actions = append(actions, TailedAction{ Seq: seq })
+		case i % 10 == 0:
+			actions = append(actions, testRamAction(t, seq, "bob", 100))
+		default:
+			actions = append(actions, testRamAction(t, seq, "alice", 1))
+		}
+	}
+	return actions
+}
+
+func testRamTotals(actions []TailedAction) *RamTotals {
+	totals := newRamTotals(nil, func() map[string][]string {
+		return map[string][]string{ ActionTracesIndexPrefix: []string{ "action_traces-1" } }
+	}, 0)
+	totals.Search = testActionsSearch(actions)
+	return totals
+}
+
+
+func TestReplayRamDeltas(t *testing.T) {
+	actions := testRamActions(t, 0, 2500)
+	checkpoints, err := replayRamDeltas(testActionsSearch(actions), nil, "alice", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	//checkpoint after every batch and at the last action
+	expected := []ramCheckpoint { { 2000, 899 }, { 4000, 1799 }, { 5000, 2249 } }
+	if !reflect.DeepEqual(checkpoints, expected) {
+		t.Errorf("expected %+v, got %+v", expected, checkpoints)
+	}
+
+	//no new actions
+	unchanged, err := replayRamDeltas(testActionsSearch(actions), nil, "alice", checkpoints)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(unchanged, expected) {
+		t.Errorf("expected %+v, got %+v", expected, unchanged)
+	}
+
+	//action indexed out of order within the rescan window is counted
+	late := testRamAction(t, 4001, "alice", 50)
+	actions = append(append(append(make([]TailedAction, 0), actions[:2000]...), late), actions[2000:]...)
+	actions = append(actions, testRamAction(t, 5001, "alice", -10))
+	refreshed, err := replayRamDeltas(testActionsSearch(actions), nil, "alice", checkpoints)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = []ramCheckpoint { { 2000, 899 }, { 4000, 1799 }, { 5001, 2289 } }
+	if !reflect.DeepEqual(refreshed, expected) {
+		t.Errorf("expected %+v, got %+v", expected, refreshed)
+	}
+	if checkpoints[len(checkpoints) - 1].Seq != 5000 {
+		t.Errorf("replay modified the cached checkpoints: %+v", checkpoints)
+	}
+}
+
+func TestReplayRamDeltasRescanWindow(t *testing.T) {
+	//checkpoints older than the rescan window are kept, newer ones are replayed again
+	checkpoints := []ramCheckpoint { { 1000, 50 }, { 300000, 80 } }
+	actions := []TailedAction {
+		testRamAction(t, 250000, "alice", 5),
+		testRamAction(t, 300001, "alice", 1),
+	}
+	var afterSeqs []uint64
+	search := func(indices []string, query elastic.Query, afterSeq uint64, size int) (*ActionsPage, error) {
+		afterSeqs = append(afterSeqs, afterSeq)
+		return testActionsSearch(actions)(indices, query, afterSeq, size)
+	}
+	result, err := replayRamDeltas(search, nil, "alice", checkpoints)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ramCheckpoint { { 1000, 50 }, { 300001, 56 } }
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+	if !reflect.DeepEqual(afterSeqs, []uint64{ 1000 }) {
+		t.Errorf("expected replay after 1000, got %v", afterSeqs)
+	}
+}
+
+func TestRamTotalsSumBefore(t *testing.T) {
+	actions := testRamActions(t, 0, 2500)
+	totals := testRamTotals(actions)
+	tests := []struct {
+		seq      uint64
+		expected int64
+	}{
+		{ 0, 0 },
+		{ 2, 0 },
+		{ 3, 1 },
+		{ 15, 6 },
+		{ 2000, 899 },
+		{ 2001, 899 },
+		{ 2003, 900 },
+		{ 4001, 1799 },
+		{ 4998, 2248 },
+		{ 4999, 2249 },
+		{ 10000, 2249 },
+	}
+	for _, test := range tests {
+		sum, err := totals.sumBefore("alice", test.seq)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %v", test.seq, err)
+			continue
+		}
+		if sum != test.expected {
+			t.Errorf("%d: expected %d, got %d", test.seq, test.expected, sum)
+		}
+	}
+	//the first request replayed and cached checkpoints
+	if entry, ok := totals.Entries["alice"]; !ok || len(entry.Checkpoints) != 3 {
+		t.Errorf("expected cached checkpoints, got %+v", totals.Entries)
+	}
+	if len(totals.Replays) != 0 {
+		t.Errorf("expected no running replays, got %d", len(totals.Replays))
+	}
+}
+
+func TestRamTotalsSingleRefresh(t *testing.T) {
+	totals := testRamTotals(nil)
+	totals.Search = func(indices []string, query elastic.Query, afterSeq uint64, size int) (*ActionsPage, error) {
+		t.Errorf("unexpected replay after %d", afterSeq)
+		return &ActionsPage{ LastSeq: afterSeq }, nil
+	}
+	outdated := []ramCheckpoint { { 10, 5 } }
+	totals.Entries["alice"] = &ramTotalsEntry { Checkpoints: outdated, CheckedAt: time.Now().Add(-time.Hour) }
+	//another request is refreshing checkpoints of alice
+	totals.Replays["alice"] = &ramReplay{ Done: make(chan struct{}) }
+
+	checkpoints, err := totals.checkpoints("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(checkpoints, outdated) {
+		t.Errorf("expected outdated checkpoints %+v, got %+v", outdated, checkpoints)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"github.com/olivere/elastic"
+)
+
+
+const ResourceUsageIntervalHour string = "hour"
+const ResourceUsageIntervalDay  string = "day"
+
+//RamDeltaScript sums RAM deltas of the account in account_ram_deltas of action trace
+//deltas are kept in _source only, account_ram_deltas is an array of objects
+const RamDeltaScript string = `
+long sum = 0;
+def deltas = params._source.account_ram_deltas;
+if (deltas != null) {
+	for (def d : deltas) {
+		if (d.account == params.account) {
+			sum += Long.parseLong(d.delta.toString());
+		}
+	}
+}
+return sum;`
+
+
+//newRamDeltaAggregation returns aggregation which sums RAM deltas of account
+func newRamDeltaAggregation(account string) *elastic.SumAggregation {
+	script := elastic.NewScript(RamDeltaScript).Lang("painless").Param("account", account)
+	return elastic.NewSumAggregation().Script(script)
+}
+
+//getResourceUsage aggregates CPU and NET billed to account and its RAM deltas by time buckets
+//CPU and NET are summed over transaction_traces where the account authorized any action
+//(every authorizer is billed for the whole transaction), RAM deltas are summed over action_traces
+//both searches are sent in one msearch and their buckets are merged by time
+func getResourceUsage(client *elastic.Client, params GetResourceUsageParams, indices map[string][]string) (*GetResourceUsageResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name is required"))
+	}
+	interval := params.Interval
+	if len(interval) == 0 {
+		interval = ResourceUsageIntervalDay
+	}
+	if interval != ResourceUsageIntervalHour && interval != ResourceUsageIntervalDay {
+		return nil, newErrorWithCode(400, errors.New("interval must be one of: hour, day"))
+	}
+	result := new(GetResourceUsageResult)
+	result.AccountName = params.AccountName
+	result.Interval = interval
+	result.Buckets = make([]ResourceUsageBucket, 0)
+	if len(indices[TransactionTracesIndexPrefix]) == 0 && len(indices[ActionTracesIndexPrefix]) == 0 {
+		return result, nil
+	}
+
+	var timeRange *elastic.RangeQuery
+	if len(params.After) > 0 || len(params.Before) > 0 {
+		timeRange = elastic.NewRangeQuery("block_time")
+		if len(params.After) > 0 {
+			timeRange = timeRange.Gte(params.After)
+		}
+		if len(params.Before) > 0 {
+			timeRange = timeRange.Lte(params.Before)
+		}
+	}
+	trxQuery := elastic.NewBoolQuery().
+		Filter(elastic.NewMatchQuery("action_traces.act.authorization.actor", params.AccountName)).
+		MustNot(elastic.NewMatchQuery("receipt.status", "hard_fail"))
+	ramQuery := elastic.NewBoolQuery().
+		Filter(elastic.NewMatchQuery("account_ram_deltas.account", params.AccountName))
+	if timeRange != nil {
+		trxQuery = trxQuery.Filter(timeRange)
+		ramQuery = ramQuery.Filter(timeRange)
+	}
+	trxHistogram := elastic.NewDateHistogramAggregation().Field("block_time").Interval(interval).MinDocCount(1).
+		SubAggregation("cpu_usage_us", elastic.NewSumAggregation().Field("receipt.cpu_usage_us")).
+		SubAggregation("net_usage_words", elastic.NewSumAggregation().Field("receipt.net_usage_words"))
+	ramHistogram := elastic.NewDateHistogramAggregation().Field("block_time").Interval(interval).MinDocCount(1).
+		SubAggregation("ram_delta", newRamDeltaAggregation(params.AccountName))
+
+	buckets := make(map[string]*ResourceUsageBucket)
+	bucket := func(item *elastic.AggregationBucketHistogramItem) *ResourceUsageBucket {
+		key := ""
+		if item.KeyAsString != nil {
+			key = *item.KeyAsString
+		}
+		if buckets[key] == nil {
+			buckets[key] = &ResourceUsageBucket { Time: key, Key: int64(item.Key) }
+		}
+		return buckets[key]
+	}
+
+	msearch := client.MultiSearch()
+	requests := make([]string, 0, 2)
+	if len(indices[TransactionTracesIndexPrefix]) > 0 {
+		msearch.Add(elastic.NewSearchRequest().Index(indices[TransactionTracesIndexPrefix]...).
+			Query(trxQuery).Size(0).Aggregation("usage", trxHistogram))
+		requests = append(requests, TransactionTracesIndexPrefix)
+	}
+	if len(indices[ActionTracesIndexPrefix]) > 0 {
+		msearch.Add(elastic.NewSearchRequest().Index(indices[ActionTracesIndexPrefix]...).
+			Query(ramQuery).Size(0).Aggregation("usage", ramHistogram))
+		requests = append(requests, ActionTracesIndexPrefix)
+	}
+	msearchResult, err := msearch.Do(context.Background())
+	if err != nil || msearchResult == nil || len(msearchResult.Responses) != len(requests) {
+		if err == nil {
+			err = errors.New("Empty ES response")
+		}
+		return nil, newErrorWithCode(500, err)
+	}
+	for i, resp := range msearchResult.Responses {
+		if resp == nil || resp.Error != nil {
+			return nil, newErrorWithCode(500, errors.New("Failed to aggregate " + requests[i]))
+		}
+		histogram, ok := resp.Aggregations.DateHistogram("usage")
+		if !ok {
+			continue
+		}
+		for _, item := range histogram.Buckets {
+			b := bucket(item)
+			if requests[i] == TransactionTracesIndexPrefix {
+				b.Transactions = item.DocCount
+				if sum, ok := item.Aggregations.Sum("cpu_usage_us"); ok && sum.Value != nil {
+					b.CpuUsageUs = int64(*sum.Value)
+				}
+				if sum, ok := item.Aggregations.Sum("net_usage_words"); ok && sum.Value != nil {
+					b.NetUsageWords = int64(*sum.Value)
+				}
+				b.NetUsageBytes = b.NetUsageWords * 8
+			} else {
+				b.RamActions = item.DocCount
+				if sum, ok := item.Aggregations.Sum("ram_delta"); ok && sum.Value != nil {
+					b.RamDelta = int64(*sum.Value)
+				}
+			}
+		}
+	}
+
+	for _, b := range buckets {
+		result.Buckets = append(result.Buckets, *b)
+		result.Total.Transactions += b.Transactions
+		result.Total.CpuUsageUs += b.CpuUsageUs
+		result.Total.NetUsageWords += b.NetUsageWords
+		result.Total.NetUsageBytes += b.NetUsageBytes
+		result.Total.RamActions += b.RamActions
+		result.Total.RamDelta += b.RamDelta
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool {
+		return result.Buckets[i].Key < result.Buckets[j].Key
+	})
+	return result, nil
+}
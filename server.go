@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"time"
 	"sync"
 	"io/ioutil"
@@ -12,23 +14,47 @@ import (
 
 
 const ApiPath                      string = "/v1/history/"
+const ChainApiPath                 string = "/v1/chain/"
 const AccountsIndexPrefix          string = "accounts"
 const TransactionsIndexPrefix      string = "transactions"
 const TransactionTracesIndexPrefix string = "transaction_traces"
 const ActionTracesIndexPrefix      string = "action_traces"
+const BlocksIndexPrefix            string = "blocks"
 const FetchIndexListIntervalSeconds int64 = 30
 
 
 type Config struct {
-	Port       uint32 `json:"port"`
-	ElasticUrl string `json:"elastic_url"`
+	Port                           uint32 `json:"port"`
+	ElasticUrl                     string `json:"elastic_url"`
+	NodeUrls                     []string `json:"node_urls"`
+	NodeHealthCheckIntervalSeconds  int64 `json:"node_health_check_interval_seconds"`
+	NodeMaxBlockLag                uint64 `json:"node_max_block_lag"`
+	ChainInfoPollIntervalMs         int64 `json:"chain_info_poll_interval_ms"`
+	ChainInfoMaxAgeMs               int64 `json:"chain_info_max_age_ms"`
+	IndexPattern                   string `json:"index_pattern"`
+	ActionCountCacheSize              int `json:"action_count_cache_size"`
+	StreamPollIntervalMs            int64 `json:"stream_poll_interval_ms"`
+	StreamAllowedOrigins         []string `json:"stream_allowed_origins"`
+	WebhooksFile                   string `json:"webhooks_file"`
+	AdminToken                     string `json:"admin_token"`
 }
 
 
 type Server struct {
 	ElasticUrl string
     ElasticClient *elastic.Client
+	IndexPattern string
 	Indices map[string][]string
+	IndicesInfo map[string]IndexInfo
+	ActionCounts *ActionCountCache
+	Abis *AbiHistory
+	Balances *BalanceHistory
+	RamTotals *RamTotals
+	Permissions *PermissionHistory
+	Nodes *NodePool
+	ChainInfo *ChainInfoCache
+	Tailer *ActionTailer
+	Webhooks *WebhookManager
 	//syncronization for Indices
 	Wg1 sync.WaitGroup
 	Wg2 sync.WaitGroup
@@ -43,7 +69,7 @@ func (s * Server) listen(port uint32) {
 }
 
 
-func (s *Server) initElasticClient(url string) {
+func (s *Server) initElasticClient(url string, indexPattern string, actionCountCacheSize int) {
 	client, err := elastic.NewClient(
 		elastic.SetURL(url),
 		elastic.SetSniff(false))
@@ -52,6 +78,15 @@ func (s *Server) initElasticClient(url string) {
 	} else {
 		s.ElasticClient = client
 		s.ElasticUrl = url
+		s.IndexPattern = indexPattern
+		s.ActionCounts = newActionCountCache(actionCountCacheSize)
+		s.Abis = newAbiHistory(client, s.getIndices, 0)
+		s.Balances = newBalanceHistory(client, s.getIndices, 0)
+		s.RamTotals = newRamTotals(client, s.getIndices, 0)
+		s.Permissions = newPermissionHistory(client, s.getIndices, s.Abis, 0)
+		if len(s.IndexPattern) == 0 {
+			s.IndexPattern = DefaultIndexPattern
+		}
 		go func () {
 			for {
 				s.fetchIndices()
@@ -61,11 +96,55 @@ func (s *Server) initElasticClient(url string) {
 	}
 }
 
-func (s *Server) setRoutes() {
+func (s *Server) initNodePool(urls []string, maxBlockLag uint64, checkIntervalSeconds int64) {
+	s.Nodes = newNodePool(urls, maxBlockLag)
+	s.Nodes.startHealthChecks(checkIntervalSeconds)
+}
+
+func (s *Server) initChainInfoPoller(intervalMs int64, maxAgeMs int64) {
+	if maxAgeMs <= 0 {
+		maxAgeMs = DefaultChainInfoMaxAgeMs
+	}
+	s.ChainInfo = new(ChainInfoCache)
+	s.ChainInfo.MaxAge = time.Duration(maxAgeMs) * time.Millisecond
+	s.ChainInfo.startPolling(s.Nodes, intervalMs)
+}
+
+func (s *Server) initActionTailer(intervalMs int64) {
+	s.Tailer = newActionTailer(s.ElasticClient, s.getIndices)
+	s.Tailer.start(intervalMs)
+}
+
+func (s *Server) initWebhooks(file string) {
+	webhooks, err := newWebhookManager(s, file)
+	if err != nil {
+		panic(err)
+	}
+	s.Webhooks = webhooks
+	s.Webhooks.start()
+}
+
+func (s *Server) setRoutes(streamAllowedOrigins []string) {
 	http.HandleFunc(ApiPath + "get_actions", s.onlyGetOrPost(s.handleGetActions()))
 	http.HandleFunc(ApiPath + "get_transaction", s.onlyGetOrPost(s.handleGetTransaction()))
 	http.HandleFunc(ApiPath + "get_key_accounts", s.onlyGetOrPost(s.handleGetKeyAccounts()))
 	http.HandleFunc(ApiPath + "get_controlled_accounts", s.onlyGetOrPost(s.handleGetControlledAccounts()))
+	http.HandleFunc(ApiPath + "get_block", s.onlyGetOrPost(s.handleGetBlock()))
+	http.HandleFunc(ApiPath + "get_abi_snapshot", s.onlyGetOrPost(s.handleGetAbiSnapshot()))
+	http.HandleFunc(ApiPath + "get_account", s.onlyGetOrPost(s.handleGetAccount()))
+	http.HandleFunc(ApiPath + "get_creator", s.onlyGetOrPost(s.handleGetCreator()))
+	http.HandleFunc(ApiPath + "get_created_accounts", s.onlyGetOrPost(s.handleGetCreatedAccounts()))
+	http.HandleFunc(ApiPath + "get_account_tree", s.onlyGetOrPost(s.handleGetAccountTree()))
+	http.HandleFunc(ApiPath + "get_control_graph", s.onlyGetOrPost(s.handleGetControlGraph()))
+	http.HandleFunc(ApiPath + "get_permission_history", s.onlyGetOrPost(s.handleGetPermissionHistory()))
+	http.HandleFunc(ApiPath + "get_transfers", s.onlyGetOrPost(s.handleGetTransfers()))
+	http.HandleFunc(ApiPath + "get_balance_history", s.onlyGetOrPost(s.handleGetBalanceHistory()))
+	http.HandleFunc(ApiPath + "get_resource_usage", s.onlyGetOrPost(s.handleGetResourceUsage()))
+	http.HandleFunc(ApiPath + "get_ram_deltas", s.onlyGetOrPost(s.handleGetRamDeltas()))
+	http.HandleFunc(ApiPath + "search_failed_transactions", s.onlyGetOrPost(s.handleSearchFailedTransactions()))
+	http.HandleFunc(ApiPath + "stream", s.handleStream(streamAllowedOrigins))
+	http.HandleFunc(ApiPath + "stream_transactions", s.handleTransactionsStream())
+	http.HandleFunc(ChainApiPath + "get_block", s.onlyGetOrPost(s.handleGetBlock()))
 }
 
 
@@ -85,17 +164,40 @@ func (s *Server) onlyGetOrPost(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+//fetchIndices refreshes index list and index metadata
+//if the refresh fails, last known index list is kept
 func (s *Server) fetchIndices() {
 	prefixes := []string {
 		AccountsIndexPrefix,
 		TransactionsIndexPrefix,
 		TransactionTracesIndexPrefix,
-		ActionTracesIndexPrefix }
-	tmp := getIndices(s.ElasticUrl, prefixes)
+		ActionTracesIndexPrefix,
+		BlocksIndexPrefix }
+	tmp, err := getIndices(s.ElasticClient, prefixes, s.IndexPattern)
+	if err != nil {
+		log.Printf("Failed to fetch index list, keeping previous one: %v\n", err)
+		return
+	}
+	tmpInfo, err := getIndicesInfo(s.ElasticClient, tmp, []string {
+		TransactionsIndexPrefix,
+		TransactionTracesIndexPrefix,
+		ActionTracesIndexPrefix,
+		BlocksIndexPrefix })
+	if err != nil {
+		//indices without metadata are searched as if they may contain any block
+		log.Printf("Failed to fetch index metadata, keeping previous one: %v\n", err)
+		tmpInfo = s.getIndicesInfo()
+	}
+	indicesChanged := !equalStrings(s.getIndices()[ActionTracesIndexPrefix], tmp[ActionTracesIndexPrefix])
 	s.Wg1.Add(1)
 	s.Wg2.Wait()
 	s.Indices = tmp
+	s.IndicesInfo = tmpInfo
 	s.Wg1.Done()
+	//cached action counts are bound to the list of action_traces indices
+	if indicesChanged {
+		s.ActionCounts.invalidate()
+	}
 }
 
 func (s *Server) getIndices() map[string][]string {
@@ -106,6 +208,26 @@ func (s *Server) getIndices() map[string][]string {
 	return result
 }
 
+func (s *Server) getIndicesInfo() map[string]IndexInfo {
+	s.Wg1.Wait()
+	s.Wg2.Add(1)
+	result := s.IndicesInfo
+	s.Wg2.Done()
+	return result
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, _ := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 //handleGetActions returns http handler that takes
 //http.ResponseWriter and *http.Request as arguments
 //it tries to parse parameters from request body
@@ -130,6 +252,13 @@ func (s *Server) handleGetActions() http.HandlerFunc {
 			json.NewEncoder(w).Encode(response)
 			return
 		}
+		err = validateActionsParams(params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
 		if params.Pos == nil {
 			params.Pos = new(int64)
 			*params.Pos = -1
@@ -139,7 +268,12 @@ func (s *Server) handleGetActions() http.HandlerFunc {
 			*params.Offset = -20
 		}
 
-		result, err := getActions(s.ElasticClient, params, s.getIndices())
+		var result *GetActionsResult
+		if params.Cursor != nil {
+			result, err = getActionsByCursor(s.ElasticClient, params, s.getIndices(), s.getIndicesInfo())
+		} else {
+			result, err = getActions(s.ElasticClient, params, s.getIndices(), s.getIndicesInfo(), s.ActionCounts)
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
@@ -147,9 +281,13 @@ func (s *Server) handleGetActions() http.HandlerFunc {
 			return
 		}
 
-		info, err := getInfo()
+		info, age, err := s.ChainInfo.get()
 		if err == nil {
 			result.LastIrreversibleBlock = info.LastIrreversibleBlockNum
+			result.LastIrreversibleBlockAgeMs = new(int64)
+			*result.LastIrreversibleBlockAgeMs = int64(age / time.Millisecond)
+		} else {
+			log.Printf("Failed to get chain info: %v\n", err)
 		}
 
 		b, err := json.Marshal(result)
@@ -190,15 +328,18 @@ func (s *Server) handleGetTransaction() http.HandlerFunc {
 			return
 		}
 
-		result, error := getTransaction(s.ElasticClient, params, s.getIndices())
+		result, error := getTransaction(s.ElasticClient, params, s.getIndices(), s.getIndicesInfo())
 		if error != nil {
 			w.WriteHeader(error.Code)
 			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
 			json.NewEncoder(w).Encode(response)
 			return
 		}
-		//get missing fields from v1/chain/get_block
-		txFromBlock, err := getTransactionFromBlock(result.BlockNum, result.Id)
+		//get missing fields from blocks index, fall back to v1/chain/get_block
+		txFromBlock, err := getTransactionFromEsBlock(s.ElasticClient, result.BlockNum, result.Id, s.getIndices(), s.getIndicesInfo())
+		if err != nil {
+			txFromBlock, err = s.Nodes.getTransactionFromBlock(result.BlockNum, result.Id)
+		}
 		if err == nil {
 			var receipt map[string]json.RawMessage
 			err = json.Unmarshal(result.Trx["receipt"], &receipt)
@@ -210,10 +351,29 @@ func (s *Server) handleGetTransaction() http.HandlerFunc {
 				}
 			}
 		}
+		//transaction is missing in transactions index, unpack it from the block
+		blockNum, _ := parseUint64(result.BlockNum)
+		if _, ok := result.Trx["trx"]; !ok && txFromBlock != nil {
+			trx, err := unpackBlockTransaction(s.ElasticClient, s.Abis, txFromBlock, result.Traces, blockNum, s.getIndices())
+			if err == nil {
+				result.Trx["trx"] = trx
+			}
+		} else if ok {
+			trx, err := decodeMissingActionData(s.ElasticClient, s.Abis, result.Trx["trx"], result.Traces, blockNum, s.getIndices())
+			if err == nil {
+				result.Trx["trx"] = trx
+			} else {
+				log.Printf("Failed to decode action data: %v\n", err)
+			}
+		}
 
-		info, err := getInfo()
+		info, age, err := s.ChainInfo.get()
 		if err == nil {
 			result.LastIrreversibleBlock = info.LastIrreversibleBlockNum
+			result.LastIrreversibleBlockAgeMs = new(int64)
+			*result.LastIrreversibleBlockAgeMs = int64(age / time.Millisecond)
+		} else {
+			log.Printf("Failed to get chain info: %v\n", err)
 		}
 
 		b, err := json.Marshal(result)
@@ -252,10 +412,10 @@ func (s *Server) handleGetKeyAccounts() http.HandlerFunc {
 			return
 		}
 		
-		result, err := getKeyAccounts(s.ElasticClient, params, s.getIndices())
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+		result, error := getKeyAccounts(s.ElasticClient, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
 			json.NewEncoder(w).Encode(response)
 			return
 		}
@@ -311,4 +471,583 @@ func (s *Server) handleGetControlledAccounts() http.HandlerFunc {
 		}
 		fmt.Fprintf(w, string(b))
 	}
-}
\ No newline at end of file
+}
+
+//handleGetBlock returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getBlock()
+//The result of getBlock() is converted to chain api format, encoded and sent as a response
+func (s *Server) handleGetBlock() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetBlockParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil || params.BlockNum == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		block, error := getBlock(s.ElasticClient, params, s.getIndices(), s.getIndicesInfo())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		result, err := formatChainBlock(block)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+//handleGetAbiSnapshot returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getAbiSnapshot()
+//The result of getAbiSnapshot() is encoded and sent as a response
+func (s *Server) handleGetAbiSnapshot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetAbiSnapshotParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getAbiSnapshot(s.Abis, params)
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+//handleGetAccount returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getAccounts()
+//a single account is returned for account_name, a list of accounts for account_names
+func (s *Server) handleGetAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetAccountParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil || (len(params.AccountName) == 0 && len(params.AccountNames) == 0) {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		bulk := len(params.AccountNames) > 0
+		names := params.AccountNames
+		if !bulk {
+			names = []string{ params.AccountName }
+		}
+		result, error := getAccounts(s.ElasticClient, names, s.getIndices())
+		if error == nil && !bulk && len(result.Accounts) == 0 {
+			error = newErrorWithCode(http.StatusNotFound, errors.New("Account not found"))
+		}
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		var b []byte
+		if bulk {
+			b, err = json.Marshal(result)
+		} else {
+			b, err = json.Marshal(result.Accounts[0])
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+//handleGetCreator returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getCreator()
+//The result of getCreator() is encoded and sent as a response
+func (s *Server) handleGetCreator() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetCreatorParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getCreator(s.ElasticClient, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		fmt.Fprintf(w, string(b))
+	}
+}
+
+//handleGetCreatedAccounts returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getCreatedAccounts()
+//The result of getCreatedAccounts() is encoded and sent as a response
+func (s *Server) handleGetCreatedAccounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetCreatedAccountsParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getCreatedAccounts(s.ElasticClient, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		fmt.Fprintf(w, string(b))
+	}
+}
+
+//handleGetAccountTree returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getAccountTree()
+//The result of getAccountTree() is encoded and sent as a response
+func (s *Server) handleGetAccountTree() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetAccountTreeParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getAccountTree(s.ElasticClient, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		fmt.Fprintf(w, string(b))
+	}
+}
+
+//handleGetControlGraph returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getControlGraph()
+//The result of getControlGraph() is encoded and sent as a response
+func (s *Server) handleGetControlGraph() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetControlGraphParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getControlGraph(s.ElasticClient, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		fmt.Fprintf(w, string(b))
+	}
+}
+
+//handleGetPermissionHistory returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getPermissionHistory()
+//The result of getPermissionHistory() is encoded and sent as a response
+func (s *Server) handleGetPermissionHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetPermissionHistoryParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getPermissionHistory(s.Permissions, params)
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+//handleGetTransfers returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getTransfers()
+//The result of getTransfers() is encoded and sent as a response
+func (s *Server) handleGetTransfers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetTransfersParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getTransfers(s.ElasticClient, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+//handleGetBalanceHistory returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getBalanceHistory()
+//The result of getBalanceHistory() is encoded and sent as a response
+func (s *Server) handleGetBalanceHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetBalanceHistoryParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getBalanceHistory(s.Balances, params)
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+//handleGetResourceUsage returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getResourceUsage()
+//The result of getResourceUsage() is encoded and sent as a response
+func (s *Server) handleGetResourceUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetResourceUsageParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getResourceUsage(s.ElasticClient, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+//handleGetRamDeltas returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to getRamDeltas()
+//The result of getRamDeltas() is encoded and sent as a response
+func (s *Server) handleGetRamDeltas() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params GetRamDeltasParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := getRamDeltas(s.ElasticClient, s.RamTotals, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+//handleSearchFailedTransactions returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from request body
+//and passes them to searchFailedTransactions()
+//The result of searchFailedTransactions() is encoded and sent as a response
+func (s *Server) handleSearchFailedTransactions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var params SearchFailedTransactionsParams
+		err = json.Unmarshal(bytes, &params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		result, error := searchFailedTransactions(s.ElasticClient, params, s.getIndices())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write(b)
+	}
+}
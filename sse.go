@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const SseBatchSize                int = 100
+const SsePollIntervalMs         int64 = 1000
+const SseKeepAliveIntervalSeconds int64 = 15
+
+
+//SsePosition identifies the last sent transaction
+//transactions are ordered by block number and global sequence of their first action
+type SsePosition struct {
+	BlockNum uint64
+	Seq      uint64
+}
+
+//IrreversibleTransaction is a transaction found by searchIrreversibleTransactions
+type IrreversibleTransaction struct {
+	Position SsePosition
+	Trace    *elastic.GetResult
+}
+
+
+func (p SsePosition) String() string {
+	return strconv.FormatUint(p.BlockNum, 10) + ":" + strconv.FormatUint(p.Seq, 10)
+}
+
+//parseSsePosition parses Last-Event-ID in "block_num:global_sequence" format
+func parseSsePosition(str string) (*SsePosition, error) {
+	parts := strings.Split(str, ":")
+	if len(parts) != 2 {
+		return nil, errors.New("Invalid Last-Event-ID")
+	}
+	blockNum, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid Last-Event-ID")
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid Last-Event-ID")
+	}
+	return &SsePosition{ BlockNum: blockNum, Seq: seq }, nil
+}
+
+
+//handleTransactionsStream returns http handler that sends irreversible transactions
+//as Server-Sent Events in get_transaction format
+//Stream continues after Last-Event-ID header (or last_event_id query parameter),
+//from start_block query parameter or from the current last irreversible block
+func (s *Server) handleTransactionsStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			response := ErrorResult { Code: http.StatusMethodNotAllowed, Message: "Invalid request method." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: "Streaming is not supported." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var position *SsePosition
+		var err error
+		lastEventId := r.Header.Get("Last-Event-ID")
+		if len(lastEventId) == 0 {
+			lastEventId = r.URL.Query().Get("last_event_id")
+		}
+		if len(lastEventId) > 0 {
+			position, err = parseSsePosition(lastEventId)
+		} else if startBlock := r.URL.Query().Get("start_block"); len(startBlock) > 0 {
+			var blockNum uint64
+			blockNum, err = strconv.ParseUint(startBlock, 10, 64)
+			if err == nil && blockNum == 0 {
+				err = errors.New("start_block must be positive")
+			}
+			if err == nil {
+				//skip everything before start_block
+				position = &SsePosition{ BlockNum: blockNum - 1, Seq: math.MaxInt64 }
+			}
+		} else {
+			var info *ChainGetInfoResult
+			var lib uint64
+			info, _, err = s.ChainInfo.get()
+			if err == nil {
+				lib, err = parseUint64(info.LastIrreversibleBlockNum)
+			}
+			if err == nil {
+				//skip everything which is irreversible already
+				position = &SsePosition{ BlockNum: lib, Seq: math.MaxInt64 }
+			}
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			response := ErrorResult { Code: http.StatusBadRequest, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		poll := time.NewTicker(time.Duration(SsePollIntervalMs) * time.Millisecond)
+		defer poll.Stop()
+		keepAlive := time.NewTicker(time.Duration(SseKeepAliveIntervalSeconds) * time.Second)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-poll.C:
+				err = s.sendIrreversibleTransactions(w, flusher, position)
+				if err != nil {
+					log.Printf("Failed to stream transactions: %v\n", err)
+					return
+				}
+			}
+		}
+	}
+}
+
+//sendIrreversibleTransactions sends all irreversible and fully indexed transactions after position
+//position is advanced after every sent transaction
+func (s *Server) sendIrreversibleTransactions(w http.ResponseWriter, flusher http.Flusher, position *SsePosition) error {
+	info, _, err := s.ChainInfo.get()
+	if err != nil {
+		//wait for node to become available
+		return nil
+	}
+	lib, err := parseUint64(info.LastIrreversibleBlockNum)
+	if err != nil || lib <= position.BlockNum {
+		return nil
+	}
+	//traces of a block may be still indexing although the block is irreversible,
+	//sent position can't go back, so the stream stops at the indexed head
+	head, err := indexedHeadBlock(s.ElasticClient, s.getIndices())
+	if err != nil {
+		return err
+	}
+	if head < lib {
+		lib = head
+	}
+	if lib <= position.BlockNum {
+		return nil
+	}
+	for {
+		indices := s.getIndices()
+		indicesInfo := s.getIndicesInfo()
+		transactions, err := searchIrreversibleTransactions(s.ElasticClient,
+			indicesFromBlock(indices[TransactionTracesIndexPrefix], indicesInfo, position.BlockNum),
+			*position, lib, SseBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(transactions) == 0 {
+			return nil
+		}
+		blockNums := make(map[string]uint64)
+		for _, transaction := range transactions {
+			blockNums[transaction.Trace.Id] = transaction.Position.BlockNum
+		}
+		txs, err := multiGetByIds(s.ElasticClient, blockNums, indices[TransactionsIndexPrefix], indicesInfo)
+		if err != nil {
+			return err
+		}
+		for _, transaction := range transactions {
+			result, error := createTransaction(txs[transaction.Trace.Id], transaction.Trace, false)
+			if error != nil && error.Code == http.StatusNotFound {
+				//failed transaction
+				*position = transaction.Position
+				continue
+			}
+			if error != nil {
+				return error.Error
+			}
+			result.Id = transaction.Trace.Id
+			result.LastIrreversibleBlock = info.LastIrreversibleBlockNum
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "id: %s\nevent: transaction\ndata: %s\n\n", transaction.Position.String(), data)
+			if err != nil {
+				return err
+			}
+			*position = transaction.Position
+		}
+		flusher.Flush()
+		if len(transactions) < SseBatchSize {
+			return nil
+		}
+	}
+}
+
+
+//indexedHeadBlock returns the greatest block number which is indexed completely
+//block is indexed after traces of its transactions, so all blocks up to the greatest one in blocks indices are complete,
+//without blocks indices the greatest block of transaction_traces indices may be still indexing,
+//so the block before it is returned
+func indexedHeadBlock(client *elastic.Client, indices map[string][]string) (uint64, error) {
+	if len(indices[BlocksIndexPrefix]) > 0 {
+		return maxBlockNum(client, indices[BlocksIndexPrefix])
+	}
+	head, err := maxBlockNum(client, indices[TransactionTracesIndexPrefix])
+	if err != nil || head == 0 {
+		return 0, err
+	}
+	return head - 1, nil
+}
+
+//maxBlockNum returns the greatest block_num in indices or 0 if they are empty
+func maxBlockNum(client *elastic.Client, indices []string) (uint64, error) {
+	if len(indices) == 0 {
+		return 0, nil
+	}
+	searchResult, err := client.Search(indices...).Size(0).
+		Aggregation("max_block_num", elastic.NewMaxAggregation().Field("block_num")).
+		Do(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	max, ok := searchResult.Aggregations.Max("max_block_num")
+	if !ok || max.Value == nil {
+		return 0, nil
+	}
+	return uint64(*max.Value), nil
+}
+
+
+//searchIrreversibleTransactions returns up to size transaction traces after position
+//with block number not greater than lib in ascending order
+//traces without executed actions (e.g. hard failed or expired transactions) are skipped
+func searchIrreversibleTransactions(client *elastic.Client, indices []string, position SsePosition, lib uint64, size int) ([]IrreversibleTransaction, error) {
+	if len(indices) == 0 {
+		return nil, nil
+	}
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewRangeQuery("block_num").Gte(position.BlockNum).Lte(lib)).
+		Filter(elastic.NewExistsQuery("action_traces.receipt.global_sequence")).
+		MustNot(elastic.NewMatchQuery("receipt.status", "hard_fail"))
+	searchResult, err := client.Search(indices...).
+		Query(query).
+		Sort("block_num", true).
+		Sort("action_traces.receipt.global_sequence", true).
+		SearchAfter(position.BlockNum, position.Seq).
+		Size(size).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if searchResult == nil || searchResult.Hits == nil {
+		return nil, errors.New("Empty ES response")
+	}
+	result := make([]IrreversibleTransaction, 0, len(searchResult.Hits.Hits))
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		var txTrace struct {
+			BlockNum json.RawMessage `json:"block_num"`
+			ActionTraces []struct {
+				Receipt struct {
+					GlobalSequence json.RawMessage `json:"global_sequence"`
+				} `json:"receipt"`
+			} `json:"action_traces"`
+		}
+		err = json.Unmarshal(*hit.Source, &txTrace)
+		if err != nil {
+			return nil, errors.New("Failed to parse ES response")
+		}
+		blockNum, err := parseUint64(txTrace.BlockNum)
+		if err != nil {
+			return nil, errors.New("Failed to parse ES response")
+		}
+		//ES sorts by the smallest global sequence of transaction actions
+		var seq uint64
+		for _, actionTrace := range txTrace.ActionTraces {
+			actionSeq, err := parseUint64(actionTrace.Receipt.GlobalSequence)
+			if err == nil && (seq == 0 || actionSeq < seq) {
+				seq = actionSeq
+			}
+		}
+		result = append(result, IrreversibleTransaction {
+			Position: SsePosition{ BlockNum: blockNum, Seq: seq },
+			Trace: &elastic.GetResult{ Index: hit.Index, Id: hit.Id, Source: hit.Source, Found: true } })
+	}
+	return result, nil
+}
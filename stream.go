@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"github.com/gorilla/websocket"
+)
+
+
+const StreamBufferSize          int = 64
+const StreamCatchUpBatchSize    int = 100
+const StreamMaxSubscriptions    int = 50
+const StreamWriteTimeoutSeconds int64 = 10
+const StreamPingIntervalSeconds int64 = 30
+const StreamPongTimeoutSeconds  int64 = 60
+
+
+//StreamRequest is sent by client to (re)define subscriptions
+//start_from or resume_token are used only in the first request of connection
+type StreamRequest struct {
+	Subscriptions []ActionFilter `json:"subscriptions"`
+	StartFrom            *uint64 `json:"start_from,omitempty"`
+	ResumeToken           string `json:"resume_token,omitempty"`
+}
+
+//StreamMessage is sent by server
+//type is one of "subscribed", "action", "error"
+type StreamMessage struct {
+	Type        string `json:"type"`
+	Action     *Action `json:"action,omitempty"`
+	ResumeToken string `json:"resume_token,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+//StreamResumeToken is encoded into opaque resume token
+//it holds global sequence of the last sent action and subscriptions of the connection
+type StreamResumeToken struct {
+	Seq                  uint64 `json:"s"`
+	Subscriptions []ActionFilter `json:"f"`
+}
+
+
+func encodeResumeToken(token StreamResumeToken) string {
+	bytes, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeResumeToken(str string) (*StreamResumeToken, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, errors.New("Invalid resume_token")
+	}
+	token := new(StreamResumeToken)
+	err = json.Unmarshal(bytes, token)
+	if err != nil {
+		return nil, errors.New("Invalid resume_token")
+	}
+	return token, nil
+}
+
+func validateSubscriptions(subscriptions []ActionFilter) error {
+	if len(subscriptions) == 0 || len(subscriptions) > StreamMaxSubscriptions {
+		return errors.New("Number of subscriptions must be between 1 and 50")
+	}
+	for _, filter := range subscriptions {
+		if filter.isEmpty() {
+			return errors.New("Subscription must contain account, contract or action")
+		}
+	}
+	return nil
+}
+
+
+//streamSession holds state of one websocket connection
+type streamSession struct {
+	server        *Server
+	conn          *websocket.Conn
+	subscriptions []ActionFilter
+	lastSeq       uint64
+	requests      chan StreamRequest
+	closed        chan struct{}
+	done          chan struct{}
+}
+
+
+//handleStream returns http handler that upgrades connection to websocket
+//and pushes new actions matching client subscriptions
+//The first client message must be StreamRequest
+//browsers may connect only from allowedOrigins or from the same host if the list is empty
+func (s *Server) handleStream(allowedOrigins []string) http.HandlerFunc {
+	upgrader := websocket.Upgrader { CheckOrigin: newOriginChecker(allowedOrigins) }
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		session := &streamSession {
+			server: s,
+			conn: conn,
+			requests: make(chan StreamRequest),
+			closed: make(chan struct{}),
+			done: make(chan struct{}) }
+		session.run()
+	}
+}
+
+func (ss *streamSession) send(message StreamMessage) error {
+	ss.conn.SetWriteDeadline(time.Now().Add(time.Duration(StreamWriteTimeoutSeconds) * time.Second))
+	return ss.conn.WriteJSON(message)
+}
+
+func (ss *streamSession) sendError(err error) {
+	ss.send(StreamMessage { Type: "error", Message: err.Error(),
+		ResumeToken: encodeResumeToken(StreamResumeToken{ Seq: ss.lastSeq, Subscriptions: ss.subscriptions }) })
+}
+
+//readRequests reads client messages until connection is closed
+func (ss *streamSession) readRequests() {
+	defer close(ss.closed)
+	pongTimeout := time.Duration(StreamPongTimeoutSeconds) * time.Second
+	ss.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	ss.conn.SetPongHandler(func(string) error {
+		ss.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		return nil
+	})
+	for {
+		var request StreamRequest
+		err := ss.conn.ReadJSON(&request)
+		if err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				continue
+			}
+			if _, ok := err.(*json.UnmarshalTypeError); ok {
+				continue
+			}
+			return
+		}
+		ss.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		select {
+		case ss.requests <- request:
+		case <-ss.done:
+			return
+		}
+	}
+}
+
+func (ss *streamSession) run() {
+	defer close(ss.done)
+	go ss.readRequests()
+	tailer := ss.server.Tailer
+
+	var request StreamRequest
+	select {
+	case request = <-ss.requests:
+	case <-ss.closed:
+		return
+	}
+	var ready bool
+	ss.lastSeq, ready = tailer.position()
+	ss.subscriptions = request.Subscriptions
+	if len(request.ResumeToken) > 0 {
+		token, err := decodeResumeToken(request.ResumeToken)
+		if err != nil {
+			ss.sendError(err)
+			return
+		}
+		ss.lastSeq = token.Seq
+		if len(ss.subscriptions) == 0 {
+			ss.subscriptions = token.Subscriptions
+		}
+	} else if request.StartFrom != nil {
+		ss.lastSeq = *request.StartFrom
+	} else if !ready {
+		ss.sendError(errors.New("Stream is not ready yet, try again later"))
+		return
+	}
+	err := validateSubscriptions(ss.subscriptions)
+	if err != nil {
+		ss.sendError(err)
+		return
+	}
+	err = ss.send(StreamMessage { Type: "subscribed",
+		ResumeToken: encodeResumeToken(StreamResumeToken{ Seq: ss.lastSeq, Subscriptions: ss.subscriptions }) })
+	if err != nil {
+		return
+	}
+
+	ping := time.NewTicker(time.Duration(StreamPingIntervalSeconds) * time.Second)
+	defer ping.Stop()
+	for {
+		//subscribe before catching up so that no action is lost in between
+		sub := tailer.subscribe(StreamBufferSize)
+		err = ss.catchUp()
+		if err != nil {
+			tailer.unsubscribe(sub)
+			ss.sendError(err)
+			return
+		}
+		overflowed, err := ss.streamLive(sub, ping)
+		tailer.unsubscribe(sub)
+		if err != nil || !overflowed {
+			return
+		}
+		//client was too slow for live stream, continue from ES
+	}
+}
+
+//catchUp sends actions stored in ES after lastSeq
+func (ss *streamSession) catchUp() error {
+	query := newFiltersQuery(ss.subscriptions)
+	for {
+		select {
+		case <-ss.closed:
+			return nil
+		default:
+		}
+		indices := ss.server.getIndices()
+		page, err := searchActionsAfter(ss.server.ElasticClient, indices[ActionTracesIndexPrefix],
+			query, ss.lastSeq, StreamCatchUpBatchSize)
+		if err != nil {
+			return err
+		}
+		if page.Hits == 0 {
+			return nil
+		}
+		err = ss.sendActions(page.Actions)
+		if err != nil {
+			return err
+		}
+		//skip hits which can't be parsed
+		ss.lastSeq = page.LastSeq
+	}
+}
+
+//newOriginChecker returns websocket origin check which accepts requests without Origin header
+//(non-browser clients) and requests from allowedOrigins, "*" allows any origin
+//nil is returned for empty list, so that only same host origin is accepted
+func newOriginChecker(allowedOrigins []string) func(r *http.Request) bool {
+	if len(allowedOrigins) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		allowed[strings.TrimSuffix(strings.ToLower(origin), "/")] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return len(origin) == 0 || allowed["*"] || allowed[strings.ToLower(origin)]
+	}
+}
+
+//streamLive sends actions received from tailer
+//returns true if subscription overflowed
+func (ss *streamSession) streamLive(sub *TailSubscription, ping *time.Ticker) (bool, error) {
+	for {
+		select {
+		case batch := <-sub.Actions:
+			matched := make([]TailedAction, 0)
+			for _, action := range batch {
+				if action.Seq > ss.lastSeq && matchesAny(ss.subscriptions, action.Trace) {
+					matched = append(matched, action)
+				}
+			}
+			err := ss.sendActions(matched)
+			if err != nil {
+				return false, err
+			}
+		case <-sub.Overflow:
+			return true, nil
+		case request := <-ss.requests:
+			err := validateSubscriptions(request.Subscriptions)
+			if err != nil {
+				ss.sendError(err)
+				continue
+			}
+			ss.subscriptions = request.Subscriptions
+			err = ss.send(StreamMessage { Type: "subscribed",
+				ResumeToken: encodeResumeToken(StreamResumeToken{ Seq: ss.lastSeq, Subscriptions: ss.subscriptions }) })
+			if err != nil {
+				return false, err
+			}
+		case <-ping.C:
+			deadline := time.Now().Add(time.Duration(StreamWriteTimeoutSeconds) * time.Second)
+			err := ss.conn.WriteControl(websocket.PingMessage, nil, deadline)
+			if err != nil {
+				return false, err
+			}
+		case <-ss.closed:
+			return false, nil
+		}
+	}
+}
+
+func (ss *streamSession) sendActions(actions []TailedAction) error {
+	if len(actions) == 0 {
+		return nil
+	}
+	result, err := createTailedActions(ss.server.ElasticClient, actions,
+		ss.server.getIndices(), ss.server.getIndicesInfo())
+	if err != nil {
+		return err
+	}
+	for i, _ := range result {
+		ss.lastSeq = actions[i].Seq
+		err = ss.send(StreamMessage { Type: "action", Action: &result[i],
+			ResumeToken: encodeResumeToken(StreamResumeToken{ Seq: ss.lastSeq, Subscriptions: ss.subscriptions }) })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
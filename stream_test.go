@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+
+func TestOriginChecker(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		origin   string
+		expected bool
+	}{
+		{ "no origin header", []string{ "https://example.com" }, "", true },
+		{ "allowed origin", []string{ "https://example.com" }, "https://example.com", true },
+		{ "allowed origin with trailing slash and other case", []string{ "https://Example.com/" }, "https://example.com", true },
+		{ "other origin", []string{ "https://example.com" }, "https://evil.com", false },
+		{ "other scheme", []string{ "https://example.com" }, "http://example.com", false },
+		{ "any origin", []string{ "*" }, "https://evil.com", true },
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "http://localhost/v1/history/stream", nil)
+		if len(test.origin) > 0 {
+			r.Header.Set("Origin", test.origin)
+		}
+		if result := newOriginChecker(test.allowed)(r); result != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, result)
+		}
+	}
+	if newOriginChecker(nil) != nil {
+		t.Errorf("empty list: expected default same host check")
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultTailPollIntervalMs int64 = 1000
+const TailBatchSize               int = 1000
+const TailGapTimeoutMs          int64 = 5000
+
+
+//TailedAction is an action found by ActionTailer
+type TailedAction struct {
+	Seq   uint64
+	Trace *ActionTrace
+}
+
+//TailSubscription receives batches of new actions from ActionTailer
+//Overflow is closed when subscriber doesn't read fast enough,
+//no more batches are sent to it after that
+type TailSubscription struct {
+	Actions    chan []TailedAction
+	Overflow   chan struct{}
+	overflowed bool
+}
+
+//pendingAction is an action which waits for lower global sequences to be indexed
+type pendingAction struct {
+	Action TailedAction
+	Seen   time.Time
+}
+
+//ActionTailer polls the newest action_traces indices for actions
+//with global sequence greater than the last broadcast one
+//and broadcasts them to subscribers in order of global sequence
+//LastSeq is the watermark: actions up to it are broadcast already,
+//actions after it are kept in Pending (used by polling goroutine only) until there is no gap before them
+type ActionTailer struct {
+	Client        *elastic.Client
+	GetIndices    func() map[string][]string
+	Ready         bool
+	LastSeq       uint64
+	Pending       map[uint64]pendingAction
+	Subscriptions map[*TailSubscription]bool
+	Mutex         sync.Mutex
+}
+
+
+func newActionTailer(client *elastic.Client, getIndices func() map[string][]string) *ActionTailer {
+	tailer := new(ActionTailer)
+	tailer.Client = client
+	tailer.GetIndices = getIndices
+	tailer.Pending = make(map[uint64]pendingAction)
+	tailer.Subscriptions = make(map[*TailSubscription]bool)
+	return tailer
+}
+
+//start runs polling in background
+func (t *ActionTailer) start(intervalMs int64) {
+	if intervalMs <= 0 {
+		intervalMs = DefaultTailPollIntervalMs
+	}
+	go func () {
+		for {
+			err := t.poll()
+			if err != nil {
+				log.Printf("Failed to poll new actions: %v\n", err)
+			}
+			time.Sleep(time.Duration(intervalMs) * time.Millisecond)
+		}
+	}()
+}
+
+//position returns global sequence of the last broadcast action
+//and false if the initial position is not known yet
+func (t *ActionTailer) position() (uint64, bool) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	return t.LastSeq, t.Ready
+}
+
+//subscribe registers new subscription
+//bufferSize is the number of batches which can wait for the subscriber
+func (t *ActionTailer) subscribe(bufferSize int) *TailSubscription {
+	sub := new(TailSubscription)
+	sub.Actions = make(chan []TailedAction, bufferSize)
+	sub.Overflow = make(chan struct{})
+	t.Mutex.Lock()
+	t.Subscriptions[sub] = true
+	t.Mutex.Unlock()
+	return sub
+}
+
+func (t *ActionTailer) unsubscribe(sub *TailSubscription) {
+	t.Mutex.Lock()
+	delete(t.Subscriptions, sub)
+	t.Mutex.Unlock()
+}
+
+//tailIndices returns two newest action_traces indices
+//the previous index is included to not miss actions written around index rollover
+func (t *ActionTailer) tailIndices() []string {
+	indices := t.GetIndices()[ActionTracesIndexPrefix]
+	if len(indices) > 2 {
+		indices = indices[len(indices) - 2:]
+	}
+	return indices
+}
+
+func (t *ActionTailer) poll() error {
+	t.Mutex.Lock()
+	ready, lastSeq := t.Ready, t.LastSeq
+	t.Mutex.Unlock()
+	if !ready {
+		return t.init()
+	}
+	indices := t.tailIndices()
+	if len(indices) == 0 {
+		return nil
+	}
+
+	//everything after the watermark is fetched again,
+	//so actions indexed later than actions with greater sequences are not lost
+	now := time.Now()
+	afterSeq := lastSeq
+	for {
+		page, err := searchActionsAfter(t.Client, indices, elastic.NewBoolQuery(), afterSeq, TailBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, action := range page.Actions {
+			if _, ok := t.Pending[action.Seq]; !ok {
+				t.Pending[action.Seq] = pendingAction{ Action: action, Seen: now }
+			}
+		}
+		afterSeq = page.LastSeq
+		if page.Hits < TailBatchSize {
+			break
+		}
+	}
+	actions := t.settle(lastSeq, now)
+	if len(actions) > 0 {
+		t.broadcast(actions, actions[len(actions) - 1].Seq)
+	}
+	return nil
+}
+
+//init sets the watermark to the current end of history
+//all action_traces indices are searched since the newest ones may be empty yet,
+//the tailer is ready from the beginning of history if there are no actions at all
+func (t *ActionTailer) init() error {
+	indices := t.GetIndices()[ActionTracesIndexPrefix]
+	if len(indices) == 0 {
+		return nil
+	}
+	searchResult, err := t.Client.Search(indices...).Size(0).
+		Aggregation("max_seq", elastic.NewMaxAggregation().Field("receipt.global_sequence")).
+		Do(context.Background())
+	if err != nil {
+		return err
+	}
+	var lastSeq uint64
+	if max, ok := searchResult.Aggregations.Max("max_seq"); ok && max.Value != nil {
+		lastSeq = uint64(*max.Value)
+	}
+	t.Mutex.Lock()
+	t.LastSeq = lastSeq
+	t.Ready = true
+	t.Mutex.Unlock()
+	return nil
+}
+
+//settle removes actions which can be broadcast from Pending and returns them in order:
+//actions which follow the watermark without a gap
+//and actions after a gap which was not filled within TailGapTimeoutMs (e.g. filtered out by indexer)
+func (t *ActionTailer) settle(lastSeq uint64, now time.Time) []TailedAction {
+	seqs := make([]uint64, 0, len(t.Pending))
+	for seq, _ := range t.Pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	result := make([]TailedAction, 0)
+	for _, seq := range seqs {
+		pending := t.Pending[seq]
+		if seq != lastSeq + 1 && now.Sub(pending.Seen) < time.Duration(TailGapTimeoutMs) * time.Millisecond {
+			break
+		}
+		result = append(result, pending.Action)
+		delete(t.Pending, seq)
+		lastSeq = seq
+	}
+	return result
+}
+
+func (t *ActionTailer) broadcast(actions []TailedAction, lastSeq uint64) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	t.LastSeq = lastSeq
+	for sub, _ := range t.Subscriptions {
+		if sub.overflowed {
+			continue
+		}
+		select {
+		case sub.Actions <- actions:
+		default:
+			sub.overflowed = true
+			close(sub.Overflow)
+		}
+	}
+}
+
+
+//ActionsPage is a page of actions found by searchActionsAfter
+//Hits counts all returned hits including the ones which can't be parsed,
+//so a page is full when Hits equals the requested size,
+//LastSeq is global sequence of the last hit, the next page starts after it
+type ActionsPage struct {
+	Actions []TailedAction
+	Hits    int
+	LastSeq uint64
+}
+
+//ActionsSearch returns a page of actions matching query after afterSeq like searchActionsAfter
+//caches which replay actions take it as a field, so that replays can be tested without ES
+type ActionsSearch func(indices []string, query elastic.Query, afterSeq uint64, size int) (*ActionsPage, error)
+
+//newActionsSearch returns ActionsSearch which searches actions with client
+func newActionsSearch(client *elastic.Client) ActionsSearch {
+	return func(indices []string, query elastic.Query, afterSeq uint64, size int) (*ActionsPage, error) {
+		return searchActionsAfter(client, indices, query, afterSeq, size)
+	}
+}
+
+//searchActionsAfter returns up to size actions matching query
+//with global sequence greater than afterSeq in ascending order
+//hits which can't be parsed are logged and skipped
+func searchActionsAfter(client *elastic.Client, indices []string, query elastic.Query, afterSeq uint64, size int) (*ActionsPage, error) {
+	page := &ActionsPage{ Actions: make([]TailedAction, 0), LastSeq: afterSeq }
+	if len(indices) == 0 {
+		return page, nil
+	}
+	searchResult, err := client.Search(indices...).
+		Query(elastic.NewBoolQuery().Filter(query, elastic.NewRangeQuery("receipt.global_sequence").Gt(afterSeq))).
+		Sort("receipt.global_sequence", true).
+		Size(size).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if searchResult == nil || searchResult.Hits == nil {
+		return nil, errors.New("Empty ES response")
+	}
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil {
+			continue
+		}
+		page.Hits++
+		//sort value is known even if _source is broken
+		if len(hit.Sort) > 0 {
+			if seq, ok := hit.Sort[0].(float64); ok && uint64(seq) > page.LastSeq {
+				page.LastSeq = uint64(seq)
+			}
+		}
+		if hit.Source == nil {
+			log.Printf("Skipped action %s without source\n", hit.Id)
+			continue
+		}
+		trace := new(ActionTrace)
+		err = json.Unmarshal(*hit.Source, trace)
+		if err != nil {
+			log.Printf("Skipped action %s which can't be parsed: %v\n", hit.Id, err)
+			continue
+		}
+		seq, err := parseUint64(trace.Receipt.GlobalSequence)
+		if err != nil {
+			log.Printf("Skipped action %s with invalid global_sequence: %v\n", hit.Id, err)
+			continue
+		}
+		if seq > page.LastSeq {
+			page.LastSeq = seq
+		}
+		page.Actions = append(page.Actions, TailedAction{ Seq: seq, Trace: trace })
+	}
+	return page, nil
+}
+
+
+//ActionFilter selects actions by account (receiver or actor), contract and action name
+//empty fields match any value
+type ActionFilter struct {
+	Account  string `json:"account,omitempty"`
+	Contract string `json:"contract,omitempty"`
+	Action   string `json:"action,omitempty"`
+}
+
+func (f ActionFilter) isEmpty() bool {
+	return len(f.Account) == 0 && len(f.Contract) == 0 && len(f.Action) == 0
+}
+
+//matches checks action trace against the filter
+func (f ActionFilter) matches(trace *ActionTrace) bool {
+	var contract, name, receiver string
+	json.Unmarshal(trace.Act.Account, &contract)
+	json.Unmarshal(trace.Act.Name, &name)
+	json.Unmarshal(trace.Receipt.Receiver, &receiver)
+	if len(f.Contract) > 0 && f.Contract != contract {
+		return false
+	}
+	if len(f.Action) > 0 && f.Action != name {
+		return false
+	}
+	if len(f.Account) > 0 && f.Account != receiver {
+		var authorization []struct {
+			Actor string `json:"actor"`
+		}
+		json.Unmarshal(trace.Act.Authorization, &authorization)
+		for _, auth := range authorization {
+			if auth.Actor == f.Account {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+//query returns ES query which matches the same actions as the filter
+func (f ActionFilter) query() *elastic.BoolQuery {
+	query := elastic.NewBoolQuery()
+	if len(f.Account) > 0 {
+		query = query.Filter(elastic.NewMultiMatchQuery(f.Account, "receipt.receiver", "act.authorization.actor"))
+	}
+	if len(f.Contract) > 0 {
+		query = query.Filter(elastic.NewMatchQuery("act.account", f.Contract))
+	}
+	if len(f.Action) > 0 {
+		query = query.Filter(elastic.NewMatchQuery("act.name", f.Action))
+	}
+	return query
+}
+
+//newFiltersQuery returns query which matches actions matching any of filters
+func newFiltersQuery(filters []ActionFilter) *elastic.BoolQuery {
+	query := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, filter := range filters {
+		query = query.Should(filter.query())
+	}
+	return elastic.NewBoolQuery().Filter(query)
+}
+
+func matchesAny(filters []ActionFilter, trace *ActionTrace) bool {
+	for _, filter := range filters {
+		if filter.matches(trace) {
+			return true
+		}
+	}
+	return false
+}
+
+
+//createTailedActions composes get_actions result items from tailed actions
+//if transaction trace is not indexed yet, action_traces document is used as action_trace
+func createTailedActions(client *elastic.Client, actions []TailedAction, indices map[string][]string, indicesInfo map[string]IndexInfo) ([]Action, error) {
+	blockNums := make(map[string]uint64)
+	for _, action := range actions {
+		blockNums[action.Trace.TrxId], _ = parseUint64(action.Trace.BlockNum)
+	}
+	txTraces, err := getTransactionTraces(client, blockNums, indices, indicesInfo)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Action, 0, len(actions))
+	for _, action := range actions {
+		var trace json.RawMessage
+		if txTrace := txTraces[action.Trace.TrxId]; txTrace != nil {
+			trace, err = formatActionTrace(txTrace, action.Trace.Receipt.GlobalSequence)
+		}
+		if trace == nil || err != nil {
+			trace, err = json.Marshal(action.Trace)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, Action { GlobalActionSeq: action.Trace.Receipt.GlobalSequence,
+			BlockNum: action.Trace.BlockNum, BlockTime: action.Trace.BlockTime,
+			ActionTrace: trace, OmitAccountActionSeq: true })
+	}
+	return result, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultTransfersLimit int64 = 100
+const MaxTransfersLimit     int64 = 1000
+const MaxTransfersScanSize    int = 10000
+const TransfersBatchSize      int = 1000
+
+const DirectionIn  string = "in"
+const DirectionOut string = "out"
+const DirectionAll string = "all"
+
+
+//transferData is data of transfer action of eosio.token compatible contracts
+type transferData struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Quantity string `json:"quantity"`
+	Memo     string `json:"memo"`
+}
+
+//parseAmountRange parses optional min_amount and max_amount parameters
+func parseAmountRange(params GetTransfersParams) (*big.Rat, *big.Rat, error) {
+	var min, max *big.Rat
+	if len(params.MinAmount) > 0 {
+		value, ok := new(big.Rat).SetString(params.MinAmount)
+		if !ok {
+			return nil, nil, errors.New("Invalid min_amount")
+		}
+		min = value
+	}
+	if len(params.MaxAmount) > 0 {
+		value, ok := new(big.Rat).SetString(params.MaxAmount)
+		if !ok {
+			return nil, nil, errors.New("Invalid max_amount")
+		}
+		max = value
+	}
+	if min != nil && max != nil && min.Cmp(max) > 0 {
+		return nil, nil, errors.New("min_amount must not be greater than max_amount")
+	}
+	return min, max, nil
+}
+
+//newTransfersQuery returns query that matches transfer actions of account
+//only the receipt of the account is matched, so every transfer is found once
+func newTransfersQuery(params GetTransfersParams) elastic.Query {
+	query := elastic.NewBoolQuery()
+	query = query.Filter(elastic.NewMatchQuery("act.name", "transfer"))
+	query = query.Filter(elastic.NewMatchQuery("receipt.receiver", params.AccountName))
+	if len(params.Contract) > 0 {
+		query = query.Filter(elastic.NewMatchQuery("act.account", params.Contract))
+	}
+	if len(params.Symbol) > 0 {
+		query = query.Filter(elastic.NewMatchQuery("act.data.quantity", params.Symbol))
+	}
+	outgoing := elastic.NewBoolQuery().Filter(elastic.NewMatchQuery("act.data.from", params.AccountName))
+	incoming := elastic.NewBoolQuery().Filter(elastic.NewMatchQuery("act.data.to", params.AccountName))
+	if len(params.Counterparty) > 0 {
+		outgoing = outgoing.Filter(elastic.NewMatchQuery("act.data.to", params.Counterparty))
+		incoming = incoming.Filter(elastic.NewMatchQuery("act.data.from", params.Counterparty))
+	}
+	switch params.Direction {
+	case DirectionOut:
+		query = query.Filter(outgoing)
+	case DirectionIn:
+		query = query.Filter(incoming)
+	default:
+		query = query.Filter(elastic.NewBoolQuery().MinimumNumberShouldMatch(1).Should(outgoing, incoming))
+	}
+	if len(params.After) > 0 || len(params.Before) > 0 {
+		timeRange := elastic.NewRangeQuery("block_time")
+		if len(params.After) > 0 {
+			timeRange = timeRange.Gte(params.After)
+		}
+		if len(params.Before) > 0 {
+			timeRange = timeRange.Lte(params.Before)
+		}
+		query = query.Filter(timeRange)
+	}
+	if len(params.Memo) > 0 {
+		query = query.Must(elastic.NewMatchQuery("act.data.memo", params.Memo).Operator("and"))
+	}
+	return query
+}
+
+//transfersCursorFilters returns short hash of transfer filters which is stored in cursor,
+//so that cursor of one query can't be used to page another one
+func transfersCursorFilters(params GetTransfersParams) string {
+	direction := params.Direction
+	if len(direction) == 0 {
+		direction = DirectionAll
+	}
+	key := strings.Join([]string { params.AccountName, params.Contract, params.Symbol, direction,
+		params.Counterparty, params.MinAmount, params.MaxAmount, params.After, params.Before, params.Memo }, "\n")
+	hash := sha256.Sum256([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(hash[:9])
+}
+
+//newTransfer converts action trace into transfer row
+//returns nil if action data is not indexed, is not a transfer of account
+//or is a transfer of another symbol (symbol query is a full-text match of quantity)
+func newTransfer(trace *ActionTrace, seq uint64, params GetTransfersParams) *Transfer {
+	account := params.AccountName
+	var data transferData
+	if json.Unmarshal(trace.Act.Data, &data) != nil || (data.From != account && data.To != account) {
+		return nil
+	}
+	asset, err := parseAsset(data.Quantity)
+	if err != nil || (len(params.Symbol) > 0 && asset.Symbol != params.Symbol) {
+		return nil
+	}
+	transfer := new(Transfer)
+	transfer.GlobalActionSeq = seq
+	transfer.BlockNum = trace.BlockNum
+	transfer.BlockTime = trace.BlockTime
+	transfer.TrxId = trace.TrxId
+	json.Unmarshal(trace.Act.Account, &transfer.Contract)
+	transfer.From = data.From
+	transfer.To = data.To
+	transfer.Quantity = data.Quantity
+	transfer.Amount = asset.Rat().FloatString(asset.Precision)
+	transfer.Symbol = asset.Symbol
+	transfer.Precision = asset.Precision
+	transfer.Memo = data.Memo
+	transfer.Direction = DirectionIn
+	if data.From == account {
+		transfer.Direction = DirectionOut
+	}
+	return transfer
+}
+
+
+//getTransfers returns token transfers of account ordered by global sequence
+//amount range is checked after the search because quantity is indexed as a string,
+//so up to MaxTransfersScanSize actions are scanned per request and next_cursor
+//is returned if the page is not filled yet
+func getTransfers(client *elastic.Client, params GetTransfersParams, indices map[string][]string) (*GetTransfersResult, *ErrorWithCode) {
+	if len(params.AccountName) == 0 {
+		return nil, newErrorWithCode(400, errors.New("account_name is required"))
+	}
+	switch params.Direction {
+	case "", DirectionAll, DirectionIn, DirectionOut:
+	default:
+		return nil, newErrorWithCode(400, errors.New("direction must be one of: in, out, all"))
+	}
+	limit := DefaultTransfersLimit
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if limit <= 0 || limit > MaxTransfersLimit {
+		return nil, newErrorWithCode(400, errors.New("limit must be between 1 and " + strconv.FormatInt(MaxTransfersLimit, 10)))
+	}
+	minAmount, maxAmount, err := parseAmountRange(params)
+	if err != nil {
+		return nil, newErrorWithCode(400, err)
+	}
+	filters := transfersCursorFilters(params)
+	cursor := &ActionsCursor{ Order: OrderDesc, Filters: filters }
+	if params.Order == OrderAsc {
+		cursor.Order = OrderAsc
+	}
+	firstPage := true
+	if len(params.Cursor) > 0 {
+		cursor, err = decodeActionsCursor(params.Cursor)
+		if err != nil || cursor.Backward {
+			return nil, newErrorWithCode(400, errors.New("Invalid cursor"))
+		}
+		if cursor.Filters != filters {
+			return nil, newErrorWithCode(400, errors.New("Filters don't match the cursor, request the first page with new filters"))
+		}
+		firstPage = false
+	}
+	ascOrder := cursor.Order == OrderAsc
+
+	result := new(GetTransfersResult)
+	result.Transfers = make([]Transfer, 0)
+	if len(indices[ActionTracesIndexPrefix]) == 0 {
+		return result, nil
+	}
+	query := newTransfersQuery(params)
+	scanned := 0
+	for scanned < MaxTransfersScanSize {
+		search := client.Search(indices[ActionTracesIndexPrefix]...).
+			Query(query).
+			Sort("receipt.global_sequence", ascOrder).
+			Size(TransfersBatchSize)
+		if !firstPage {
+			search = search.SearchAfter(cursor.Seq)
+		}
+		searchResult, err := search.Do(context.Background())
+		if err != nil {
+			return nil, newErrorWithCode(500, err)
+		}
+		if searchResult == nil || searchResult.Hits == nil {
+			return nil, newErrorWithCode(500, errors.New("Empty ES response"))
+		}
+		for _, hit := range searchResult.Hits.Hits {
+			if hit == nil || hit.Source == nil {
+				continue
+			}
+			var actionTrace ActionTrace
+			err = json.Unmarshal(*hit.Source, &actionTrace)
+			if err != nil {
+				return nil, newErrorWithCode(500, errors.New("Failed to parse ES response"))
+			}
+			seq, err := parseUint64(actionTrace.Receipt.GlobalSequence)
+			if err != nil {
+				continue
+			}
+			scanned++
+			cursor.Seq = seq
+			firstPage = false
+			transfer := newTransfer(&actionTrace, seq, params)
+			if transfer != nil && transferInRange(transfer, minAmount, maxAmount) {
+				result.Transfers = append(result.Transfers, *transfer)
+				if int64(len(result.Transfers)) == limit {
+					result.NextCursor = encodeActionsCursor(*cursor)
+					return result, nil
+				}
+			}
+		}
+		if len(searchResult.Hits.Hits) < TransfersBatchSize {
+			return result, nil
+		}
+	}
+	//page is not filled, client continues scanning with the cursor
+	result.NextCursor = encodeActionsCursor(*cursor)
+	return result, nil
+}
+
+func transferInRange(transfer *Transfer, min *big.Rat, max *big.Rat) bool {
+	if min == nil && max == nil {
+		return true
+	}
+	asset, err := parseAsset(transfer.Quantity)
+	if err != nil {
+		return false
+	}
+	amount := asset.Rat()
+	return (min == nil || amount.Cmp(min) >= 0) && (max == nil || amount.Cmp(max) <= 0)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+
+func TestNewTransferSymbol(t *testing.T) {
+	params := GetTransfersParams{ AccountName: "alice", Symbol: "EOS" }
+	tests := []struct {
+		quantity string
+		matched  bool
+	}{
+		{ "1.5000 EOS", true },
+		//quantity of another symbol found by the analyzed match query
+		{ "1.5000 EOSDT", false },
+		{ "1.5000 eos", false },
+		{ "EOS", false },
+	}
+	for _, test := range tests {
+		trace := testTrace(t, 1, 1, `{ "account": "eosio.token", "name": "transfer",
+			"data": { "from": "bob", "to": "alice", "quantity": "` + test.quantity + `", "memo": "" } }`)
+		transfer := newTransfer(trace.Trace, trace.Seq, params)
+		if (transfer != nil) != test.matched {
+			t.Errorf("%q: expected matched %v, got %+v", test.quantity, test.matched, transfer)
+			continue
+		}
+		if transfer != nil && (transfer.Amount != "1.5000" || transfer.Precision != 4 || transfer.Direction != DirectionIn) {
+			t.Errorf("%q: unexpected transfer %+v", test.quantity, transfer)
+		}
+	}
+}
+
+func TestTransfersCursorFilters(t *testing.T) {
+	params := GetTransfersParams{ AccountName: "alice", Contract: "eosio.token", Symbol: "EOS" }
+	same := params
+	same.Direction = DirectionAll
+	same.Order = OrderAsc
+	same.Limit = new(int64)
+	if transfersCursorFilters(params) != transfersCursorFilters(same) {
+		t.Errorf("default direction, order and limit must not change cursor filters")
+	}
+	changed := []GetTransfersParams {
+		{ AccountName: "bob", Contract: "eosio.token", Symbol: "EOS" },
+		{ AccountName: "alice", Contract: "eosio.token", Symbol: "SYS" },
+		{ AccountName: "alice", Contract: "eosio.token", Symbol: "EOS", Direction: DirectionIn },
+		{ AccountName: "alice", Contract: "eosio.token", Symbol: "EOS", MinAmount: "1" },
+		{ AccountName: "alice", Contract: "eosio.token", Symbol: "EOS", Memo: "hello" },
+		//fields are separated, so values can't be shifted between them
+		{ AccountName: "alice", Contract: "eosio.tokenEOS" },
+	}
+	for _, other := range changed {
+		if transfersCursorFilters(params) == transfersCursorFilters(other) {
+			t.Errorf("%+v: expected cursor filters to differ", other)
+		}
+	}
+}
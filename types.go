@@ -33,10 +33,306 @@ type ChainGetInfoResult struct {
 	ServerVersionString      json.RawMessage `json:"server_version_string"`
 }
 
+//get_block types
 type GetBlockParams struct {
 	BlockNum json.RawMessage `json:"block_num_or_id"`
 }
 
+//get_account types
+type GetAccountParams struct {
+	AccountName    string `json:"account_name,omitempty"`
+	AccountNames []string `json:"account_names,omitempty"`
+}
+
+type GetAccountResult struct {
+	AccountName              string `json:"account_name"`
+	Creator          json.RawMessage `json:"creator"`
+	AccountCreateTime json.RawMessage `json:"account_create_time"`
+	CreatedTrxId             string `json:"created_trx_id,omitempty"`
+	CreatedBlockNum  json.RawMessage `json:"created_block_num,omitempty"`
+	PubKeys          json.RawMessage `json:"pub_keys"`
+	AccountControls  json.RawMessage `json:"account_controls"`
+	Abi              json.RawMessage `json:"abi"`
+}
+
+type GetAccountsResult struct {
+	Accounts []GetAccountResult `json:"accounts"`
+	NotFound           []string `json:"not_found"`
+}
+
+//get_creator types
+type GetCreatorParams struct {
+	AccountName string `json:"account_name"`
+}
+
+type GetCreatorResult struct {
+	AccountName              string `json:"account_name"`
+	Creator                  string `json:"creator"`
+	AccountCreateTime json.RawMessage `json:"account_create_time"`
+	CreatedBlockNum  json.RawMessage `json:"created_block_num,omitempty"`
+	CreatedTrxId             string `json:"created_trx_id,omitempty"`
+}
+
+
+//get_created_accounts types
+type GetCreatedAccountsParams struct {
+	AccountName string `json:"account_name"`
+	Pos          *int64 `json:"pos,omitempty"`
+	Limit        *int64 `json:"limit,omitempty"`
+}
+
+type CreatedAccount struct {
+	AccountName              string `json:"account_name"`
+	AccountCreateTime json.RawMessage `json:"account_create_time"`
+}
+
+type GetCreatedAccountsResult struct {
+	Accounts []CreatedAccount `json:"accounts"`
+	Total               int64 `json:"total"`
+}
+
+
+//get_account_tree types
+type GetAccountTreeParams struct {
+	AccountName string `json:"account_name"`
+	Depth         *int `json:"depth,omitempty"`
+}
+
+type AccountTreeNode struct {
+	AccountName              string `json:"account_name"`
+	AccountCreateTime json.RawMessage `json:"account_create_time,omitempty"`
+	Children       []*AccountTreeNode `json:"children,omitempty"`
+}
+
+type GetAccountTreeResult struct {
+	Tree *AccountTreeNode `json:"tree"`
+	Truncated        bool `json:"truncated"`
+}
+
+//get_control_graph types
+type GetControlGraphParams struct {
+	ControllingAccount string `json:"controlling_account"`
+	Depth                *int `json:"depth,omitempty"`
+}
+
+//ControlEdge means that Controller@ControllerPermission is a member of Account@Permission authority
+//weight and threshold are omitted if authority of the permission is not indexed
+type ControlEdge struct {
+	From                 string `json:"from"`
+	To                   string `json:"to"`
+	Controller           string `json:"controller"`
+	ControllerPermission string `json:"controller_permission,omitempty"`
+	Account              string `json:"account"`
+	Permission           string `json:"permission"`
+	Weight              *uint64 `json:"weight,omitempty"`
+	Threshold           *uint64 `json:"threshold,omitempty"`
+	Depth                   int `json:"depth"`
+}
+
+type GetControlGraphResult struct {
+	Edges []ControlEdge `json:"edges"`
+	Accounts   []string `json:"accounts"`
+	Truncated      bool `json:"truncated"`
+}
+
+//get_permission_history types
+type GetPermissionHistoryParams struct {
+	AccountName string `json:"account_name"`
+	BlockNum   *uint64 `json:"block_num,omitempty"`
+}
+
+//PermissionChange is a change of account permission or permission link made by one action
+type PermissionChange struct {
+	Action                   string `json:"action"`
+	Permission               string `json:"permission,omitempty"`
+	Parent                   string `json:"parent,omitempty"`
+	Auth                 *Authority `json:"auth,omitempty"`
+	Code                     string `json:"code,omitempty"`
+	Type                     string `json:"type,omitempty"`
+	Requirement              string `json:"requirement,omitempty"`
+	BlockNum                 uint64 `json:"block_num"`
+	BlockTime       json.RawMessage `json:"block_time"`
+	TrxId                    string `json:"trx_id"`
+	GlobalActionSeq          uint64 `json:"global_action_seq"`
+}
+
+type EffectivePermission struct {
+	Permission      string `json:"permission"`
+	Parent          string `json:"parent"`
+	Auth        *Authority `json:"auth"`
+	UpdatedBlockNum uint64 `json:"updated_block_num"`
+}
+
+type PermissionLink struct {
+	Code            string `json:"code"`
+	Type            string `json:"type"`
+	Requirement     string `json:"requirement"`
+	UpdatedBlockNum uint64 `json:"updated_block_num"`
+}
+
+type GetPermissionHistoryResult struct {
+	AccountName                     string `json:"account_name"`
+	Changes          []PermissionChange `json:"changes"`
+	Permissions   []EffectivePermission `json:"permissions"`
+	Links              []PermissionLink `json:"links"`
+	Truncated                         bool `json:"truncated"`
+}
+
+//get_transfers types
+type GetTransfersParams struct {
+	AccountName  string `json:"account_name"`
+	Contract     string `json:"contract,omitempty"`
+	Symbol       string `json:"symbol,omitempty"`
+	Direction    string `json:"direction,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`
+	MinAmount    string `json:"min_amount,omitempty"`
+	MaxAmount    string `json:"max_amount,omitempty"`
+	After        string `json:"after,omitempty"`
+	Before       string `json:"before,omitempty"`
+	Memo         string `json:"memo,omitempty"`
+	Order        string `json:"order,omitempty"`
+	Cursor       string `json:"cursor,omitempty"`
+	Limit       *int64 `json:"limit,omitempty"`
+}
+
+type Transfer struct {
+	GlobalActionSeq          uint64 `json:"global_action_seq"`
+	BlockNum        json.RawMessage `json:"block_num"`
+	BlockTime       json.RawMessage `json:"block_time"`
+	TrxId                    string `json:"trx_id"`
+	Contract                 string `json:"contract"`
+	From                     string `json:"from"`
+	To                       string `json:"to"`
+	Direction                string `json:"direction"`
+	Quantity                 string `json:"quantity"`
+	Amount                   string `json:"amount"`
+	Symbol                   string `json:"symbol"`
+	Precision                   int `json:"precision"`
+	Memo                     string `json:"memo"`
+}
+
+type GetTransfersResult struct {
+	Transfers []Transfer `json:"transfers"`
+	NextCursor    string `json:"next_cursor,omitempty"`
+}
+
+//get_balance_history types
+type GetBalanceHistoryParams struct {
+	AccountName string `json:"account_name"`
+	Contract    string `json:"contract"`
+	Symbol      string `json:"symbol"`
+	BlockNum   *uint64 `json:"block_num,omitempty"`
+	Limit       *int64 `json:"limit,omitempty"`
+}
+
+type GetBalanceHistoryResult struct {
+	AccountName          string `json:"account_name"`
+	Contract             string `json:"contract"`
+	Symbol               string `json:"symbol"`
+	Balance              string `json:"balance"`
+	BlockNum             uint64 `json:"block_num"`
+	History   []BalancePoint `json:"history"`
+	Truncated              bool `json:"truncated"`
+}
+
+//get_resource_usage types
+type GetResourceUsageParams struct {
+	AccountName string `json:"account_name"`
+	Interval    string `json:"interval,omitempty"`
+	After       string `json:"after,omitempty"`
+	Before      string `json:"before,omitempty"`
+}
+
+type ResourceUsageBucket struct {
+	Time          string `json:"time,omitempty"`
+	Key            int64 `json:"key,omitempty"`
+	Transactions   int64 `json:"transactions"`
+	CpuUsageUs     int64 `json:"cpu_usage_us"`
+	NetUsageWords  int64 `json:"net_usage_words"`
+	NetUsageBytes  int64 `json:"net_usage_bytes"`
+	RamActions     int64 `json:"ram_actions"`
+	RamDelta       int64 `json:"ram_delta"`
+}
+
+type GetResourceUsageResult struct {
+	AccountName                  string `json:"account_name"`
+	Interval                     string `json:"interval"`
+	Buckets   []ResourceUsageBucket `json:"buckets"`
+	Total       ResourceUsageBucket `json:"total"`
+}
+
+//get_ram_deltas types
+type GetRamDeltasParams struct {
+	AccountName string `json:"account_name"`
+	Order       string `json:"order,omitempty"`
+	Cursor      string `json:"cursor,omitempty"`
+	Limit      *int64 `json:"limit,omitempty"`
+}
+
+type RamDelta struct {
+	GlobalActionSeq          uint64 `json:"global_action_seq"`
+	BlockNum        json.RawMessage `json:"block_num"`
+	BlockTime       json.RawMessage `json:"block_time"`
+	TrxId                    string `json:"trx_id"`
+	Contract                 string `json:"contract"`
+	Action                   string `json:"action"`
+	Receiver                 string `json:"receiver"`
+	Delta                     int64 `json:"delta"`
+	RunningTotal              int64 `json:"running_total"`
+}
+
+type GetRamDeltasResult struct {
+	AccountName      string `json:"account_name"`
+	Deltas       []RamDelta `json:"deltas"`
+	NextCursor       string `json:"next_cursor,omitempty"`
+}
+
+//search_failed_transactions types
+type SearchFailedTransactionsParams struct {
+	AccountName string `json:"account_name"`
+	Status      string `json:"status,omitempty"`
+	After       string `json:"after,omitempty"`
+	Before      string `json:"before,omitempty"`
+	Order       string `json:"order,omitempty"`
+	Pos        *int64 `json:"pos,omitempty"`
+	Limit      *int64 `json:"limit,omitempty"`
+}
+
+type FailedTransactionAction struct {
+	Account                string `json:"account"`
+	Name                   string `json:"name"`
+	Authorization json.RawMessage `json:"authorization"`
+}
+
+type FailedTransaction struct {
+	Id                             string `json:"id"`
+	BlockNum              json.RawMessage `json:"block_num"`
+	BlockTime             json.RawMessage `json:"block_time"`
+	Status                         string `json:"status"`
+	Except          *TransactionException `json:"except,omitempty"`
+	Actions     []FailedTransactionAction `json:"actions"`
+}
+
+type SearchFailedTransactionsResult struct {
+	Transactions []FailedTransaction `json:"transactions"`
+	Total                      int64 `json:"total"`
+}
+
+//get_abi_snapshot types
+type GetAbiSnapshotParams struct {
+	Account   string `json:"account"`
+	BlockNum *uint64 `json:"block_num,omitempty"`
+}
+
+type GetAbiSnapshotResult struct {
+	Account           string `json:"account"`
+	BlockNum          uint64 `json:"block_num"`
+	BlockTime json.RawMessage `json:"block_time"`
+	GlobalActionSeq   uint64 `json:"global_action_seq"`
+	TrxId             string `json:"trx_id"`
+	Abi                 *Abi `json:"abi"`
+}
+
 type ChainGetBlockResult struct {
 	Transactions []struct {
 		Status        json.RawMessage `json:"status"`
@@ -57,9 +353,27 @@ type TransactionFromBlock struct {
 
 //get_actions types
 type GetActionsParams struct {
-	AccountName string `json:"account_name"`
-	Pos         *int64 `json:"pos,omitempty"`
-	Offset      *int64 `json:"offset,omitempty"`
+	AccountName   string `json:"account_name"`
+	Pos           *int64 `json:"pos,omitempty"`
+	Offset        *int64 `json:"offset,omitempty"`
+	Contract      string `json:"contract,omitempty"`
+	ActionName    string `json:"action_name,omitempty"`
+	After         string `json:"after,omitempty"`
+	Before        string `json:"before,omitempty"`
+	BlockNumFrom *uint64 `json:"block_num_from,omitempty"`
+	BlockNumTo   *uint64 `json:"block_num_to,omitempty"`
+	Match         string `json:"match,omitempty"`
+	Cursor       *string `json:"cursor,omitempty"`
+	Limit         *int64 `json:"limit,omitempty"`
+	Order         string `json:"order,omitempty"`
+}
+
+//ActionsCursor is encoded into opaque cursor strings of get_actions
+type ActionsCursor struct {
+	Seq      uint64 `json:"s"`
+	Order    string `json:"o"`
+	Backward   bool `json:"b,omitempty"`
+	Filters  string `json:"f,omitempty"`
 }
 
 type Action struct {
@@ -68,17 +382,24 @@ type Action struct {
 	BlockNum         json.RawMessage `json:"block_num"`
 	BlockTime        json.RawMessage `json:"block_time"`
 	ActionTrace      json.RawMessage `json:"action_trace"`
+	//account_action_seq is unknown in cursor mode and for streamed actions
+	OmitAccountActionSeq        bool `json:"-"`
 }
 
 type GetActionsResult struct {
 	Actions                      []Action `json:"actions"`
 	LastIrreversibleBlock json.RawMessage `json:"last_irreversible_block"`
+	LastIrreversibleBlockAgeMs     *int64 `json:"last_irreversible_block_age_ms,omitempty"`
+	NextCursor                     string `json:"next_cursor,omitempty"`
+	PrevCursor                     string `json:"prev_cursor,omitempty"`
 }
 
 
 //get_transaction types
 type GetTransactionParams struct {
 	Id           string `json:"id"`
+	BlockNumHint *uint64 `json:"block_num_hint,omitempty"`
+	ShowFailed      bool `json:"show_failed,omitempty"`
 }
 
 type GetTransactionResult struct {
@@ -87,7 +408,20 @@ type GetTransactionResult struct {
 	BlockTime             json.RawMessage `json:"block_time"`
 	BlockNum              json.RawMessage `json:"block_num"`
 	Traces                json.RawMessage `json:"traces"`
+	Status                         string `json:"status,omitempty"`
+	Except          *TransactionException `json:"except,omitempty"`
 	LastIrreversibleBlock json.RawMessage `json:"last_irreversible_block"`
+	LastIrreversibleBlockAgeMs     *int64 `json:"last_irreversible_block_age_ms,omitempty"`
+}
+
+//TransactionException is exception of failed transaction as reported by nodeos
+//messages of stack items are formatted from their format and data
+type TransactionException struct {
+	Code                      int64 `json:"code"`
+	Name                     string `json:"name"`
+	Message                  string `json:"message"`
+	Details                []string `json:"details"`
+	Stack           json.RawMessage `json:"stack,omitempty"`
 }
 
 
@@ -97,7 +431,16 @@ type GetKeyAccountsParams struct {
 }
 
 type GetKeyAccountsResult struct {
-	AccountNames []string `json:"account_names"`
+	AccountNames   []string `json:"account_names"`
+	Permissions []KeyPermission `json:"permissions"`
+}
+
+//KeyPermission is permission of account which authority contains the key
+//weight is omitted if authority of the permission is not indexed
+type KeyPermission struct {
+	AccountName string `json:"account_name"`
+	Permission  string `json:"permission"`
+	Weight     *uint64 `json:"weight,omitempty"`
 }
 
 
@@ -108,4 +451,107 @@ type GetControlledAccountsParams struct {
 
 type GetControlledAccountsResult struct {
 	ControlledAccounts []string `json:"controlled_accounts"`
-}
\ No newline at end of file
+}
+
+
+//v2 types
+type V2Envelope struct {
+	QueryTimeMs float64 `json:"query_time_ms"`
+}
+
+type V2Total struct {
+	Value      int64 `json:"value"`
+	Relation  string `json:"relation"`
+}
+
+type V2Action struct {
+	Timestamp        json.RawMessage `json:"@timestamp"`
+	BlockNum         json.RawMessage `json:"block_num"`
+	TrxId                     string `json:"trx_id"`
+	Act                  interface{} `json:"act"`
+	Receiver         json.RawMessage `json:"receiver"`
+	GlobalSequence   json.RawMessage `json:"global_sequence"`
+	Receipt              interface{} `json:"receipt"`
+	AccountRamDeltas json.RawMessage `json:"account_ram_deltas,omitempty"`
+}
+
+type V2GetActionsResult struct {
+	V2Envelope
+	Lib         json.RawMessage `json:"lib,omitempty"`
+	Total               V2Total `json:"total"`
+	Actions          []V2Action `json:"actions"`
+}
+
+type V2GetTransactionResult struct {
+	V2Envelope
+	Executed                      bool `json:"executed"`
+	TrxId                       string `json:"trx_id"`
+	Lib                json.RawMessage `json:"lib,omitempty"`
+	BlockNum           json.RawMessage `json:"block_num"`
+	BlockTime          json.RawMessage `json:"block_time"`
+	Actions []TransactionTraceActionTrace `json:"actions"`
+}
+
+type V2CreatedAccount struct {
+	Name               string `json:"name"`
+	TrxId              string `json:"trx_id"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	BlockNum  json.RawMessage `json:"block_num"`
+}
+
+type V2GetCreatedAccountsResult struct {
+	V2Envelope
+	Total                   V2Total `json:"total"`
+	Accounts     []V2CreatedAccount `json:"accounts"`
+}
+
+type V2GetCreatorResult struct {
+	V2Envelope
+	Account            string `json:"account"`
+	Creator            string `json:"creator"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	BlockNum  json.RawMessage `json:"block_num"`
+	TrxId              string `json:"trx_id"`
+}
+
+type V2GetAbiSnapshotResult struct {
+	V2Envelope
+	BlockNum  json.RawMessage `json:"block_num"`
+	Present              bool `json:"present"`
+	Abi       json.RawMessage `json:"abi,omitempty"`
+}
+
+type V2Token struct {
+	Symbol    string `json:"symbol"`
+	Precision    int `json:"precision"`
+	Amount   float64 `json:"amount"`
+	Contract  string `json:"contract"`
+}
+
+type V2GetTokensResult struct {
+	V2Envelope
+	Account       string `json:"account"`
+	Tokens     []V2Token `json:"tokens"`
+}
+
+type V2Link struct {
+	BlockNum  json.RawMessage `json:"block_num"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Account            string `json:"account"`
+	Permission         string `json:"permission"`
+	Code               string `json:"code"`
+	Action             string `json:"action"`
+	TrxId              string `json:"trx_id"`
+}
+
+type V2GetLinksResult struct {
+	V2Envelope
+	Total        V2Total `json:"total"`
+	Links       []V2Link `json:"links"`
+}
+
+type V2GetKeyAccountsResult struct {
+	V2Envelope
+	AccountNames   []string `json:"account_names"`
+	Permissions []KeyPermission `json:"permissions"`
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const V2ApiPath       string = "/v2/history/"
+const V2DefaultLimit     int = 10
+const V2MaxLimit         int = 1000
+const V2MaxTokenContracts int = 100
+const V2TokenBalanceWorkers int = 8
+const V2LinksBatchSize    int = 1000
+
+
+//v2Result is implemented by every v2 response (through V2Envelope)
+type v2Result interface {
+	setQueryTime(ms float64)
+}
+
+func (e *V2Envelope) setQueryTime(ms float64) {
+	e.QueryTimeMs = ms
+}
+
+
+func (s *Server) setV2Routes() {
+	http.HandleFunc(V2ApiPath + "get_actions", s.onlyGetOrPost(s.handleV2(s.v2GetActions)))
+	http.HandleFunc(V2ApiPath + "get_transaction", s.onlyGetOrPost(s.handleV2(s.v2GetTransaction)))
+	http.HandleFunc(V2ApiPath + "get_created_accounts", s.onlyGetOrPost(s.handleV2(s.v2GetCreatedAccounts)))
+	http.HandleFunc(V2ApiPath + "get_creator", s.onlyGetOrPost(s.handleV2(s.v2GetCreator)))
+	http.HandleFunc(V2ApiPath + "get_abi_snapshot", s.onlyGetOrPost(s.handleV2(s.v2GetAbiSnapshot)))
+	http.HandleFunc(V2ApiPath + "get_tokens", s.onlyGetOrPost(s.handleV2(s.v2GetTokens)))
+	http.HandleFunc(V2ApiPath + "get_links", s.onlyGetOrPost(s.handleV2(s.v2GetLinks)))
+	http.HandleFunc(V2ApiPath + "get_key_accounts", s.onlyGetOrPost(s.handleV2(s.v2GetKeyAccounts)))
+}
+
+
+//handleV2 returns http handler that passes query string parameters to the given function
+//and sends its result with query_time_ms filled as a response
+func (s *Server) handleV2(handler func(url.Values) (v2Result, *ErrorWithCode)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		result, error := handler(r.URL.Query())
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		result.setQueryTime(float64(time.Since(start)) / float64(time.Millisecond))
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}
+}
+
+
+func newErrorWithCode(code int, err error) *ErrorWithCode {
+	error := new(ErrorWithCode)
+	error.Error = err
+	error.Code = code
+	if error.Error == nil {
+		error.Error = errors.New(http.StatusText(code))
+	}
+	return error
+}
+
+//queryInt parses integer query string parameter
+func queryInt(params url.Values, name string, defaultValue int) (int, error) {
+	value := params.Get(name)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("Invalid " + name)
+	}
+	return result, nil
+}
+
+//queryPage parses skip and limit query string parameters
+func queryPage(params url.Values) (int, int, error) {
+	skip, err := queryInt(params, "skip", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	limit, err := queryInt(params, "limit", V2DefaultLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if skip < 0 || limit <= 0 || limit > V2MaxLimit {
+		return 0, 0, errors.New("skip must not be negative, limit must be between 1 and " + strconv.Itoa(V2MaxLimit))
+	}
+	return skip, limit, nil
+}
+
+//newSystemActionQuery returns query that matches eosio actions with given names
+//only the eosio receipt of every action is matched to skip notifications
+func newSystemActionQuery(names ...string) *elastic.BoolQuery {
+	query := elastic.NewBoolQuery()
+	query = query.Filter(elastic.NewMatchQuery("act.account", "eosio"))
+	query = query.Filter(elastic.NewMatchQuery("receipt.receiver", "eosio"))
+	actionNames := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, name := range names {
+		actionNames = actionNames.Should(elastic.NewMatchQuery("act.name", name))
+	}
+	return query.Filter(actionNames)
+}
+
+//parseV2Filter parses filter parameter in "contract:action,contract:action" format
+//where "*" matches any contract or action
+func parseV2Filter(filter string) (elastic.Query, error) {
+	query := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, item := range strings.Split(filter, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, errors.New("Invalid filter: " + item)
+		}
+		itemQuery := elastic.NewBoolQuery()
+		if parts[0] != "*" {
+			itemQuery = itemQuery.Filter(elastic.NewMatchQuery("act.account", parts[0]))
+		}
+		if parts[1] != "*" {
+			itemQuery = itemQuery.Filter(elastic.NewMatchQuery("act.name", parts[1]))
+		}
+		query = query.Should(itemQuery)
+	}
+	return query, nil
+}
+
+
+func (s *Server) v2Lib() json.RawMessage {
+	info, _, err := s.ChainInfo.get()
+	if err != nil {
+		return nil
+	}
+	return info.LastIrreversibleBlockNum
+}
+
+func (s *Server) v2GetActions(params url.Values) (v2Result, *ErrorWithCode) {
+	result, error := getV2Actions(s.ElasticClient, params, s.getIndices())
+	if error != nil {
+		return nil, error
+	}
+	result.Lib = s.v2Lib()
+	return result, nil
+}
+
+func (s *Server) v2GetTransaction(params url.Values) (v2Result, *ErrorWithCode) {
+	result, error := getV2Transaction(s.ElasticClient, params, s.getIndices(), s.getIndicesInfo())
+	if error != nil {
+		return nil, error
+	}
+	result.Lib = s.v2Lib()
+	return result, nil
+}
+
+func (s *Server) v2GetCreatedAccounts(params url.Values) (v2Result, *ErrorWithCode) {
+	return getV2CreatedAccounts(s.ElasticClient, params, s.getIndices())
+}
+
+func (s *Server) v2GetCreator(params url.Values) (v2Result, *ErrorWithCode) {
+	return getV2Creator(s.ElasticClient, params, s.getIndices())
+}
+
+func (s *Server) v2GetAbiSnapshot(params url.Values) (v2Result, *ErrorWithCode) {
+	return getV2AbiSnapshot(s.Abis, params)
+}
+
+func (s *Server) v2GetTokens(params url.Values) (v2Result, *ErrorWithCode) {
+	return getV2Tokens(s.ElasticClient, s.Nodes, params, s.getIndices())
+}
+
+func (s *Server) v2GetLinks(params url.Values) (v2Result, *ErrorWithCode) {
+	return getV2Links(s.ElasticClient, params, s.getIndices())
+}
+
+func (s *Server) v2GetKeyAccounts(params url.Values) (v2Result, *ErrorWithCode) {
+	publicKey := params.Get("public_key")
+	if len(publicKey) == 0 {
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("public_key is required"))
+	}
+	keyAccounts, error := getKeyAccounts(s.ElasticClient, GetKeyAccountsParams { PublicKey: publicKey }, s.getIndices())
+	if error != nil {
+		return nil, error
+	}
+	result := new(V2GetKeyAccountsResult)
+	result.AccountNames = keyAccounts.AccountNames
+	result.Permissions = keyAccounts.Permissions
+	return result, nil
+}
+
+
+//getV2Actions searches actions of account with hyperion-style parameters:
+//account, filter, skip, limit, sort, after, before and act.* field filters
+func getV2Actions(client *elastic.Client, params url.Values, indices map[string][]string) (*V2GetActionsResult, *ErrorWithCode) {
+	skip, limit, err := queryPage(params)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusBadRequest, err)
+	}
+	query := elastic.NewBoolQuery()
+	if account := params.Get("account"); len(account) > 0 {
+		query = query.Filter(elastic.NewMultiMatchQuery(account, "receipt.receiver", "act.authorization.actor"))
+	}
+	if filter := params.Get("filter"); len(filter) > 0 {
+		filterQuery, err := parseV2Filter(filter)
+		if err != nil {
+			return nil, newErrorWithCode(http.StatusBadRequest, err)
+		}
+		query = query.Filter(filterQuery)
+	}
+	after, before := params.Get("after"), params.Get("before")
+	if len(after) > 0 || len(before) > 0 {
+		timeRange := elastic.NewRangeQuery("block_time")
+		if len(after) > 0 {
+			timeRange = timeRange.Gte(after)
+		}
+		if len(before) > 0 {
+			timeRange = timeRange.Lte(before)
+		}
+		query = query.Filter(timeRange)
+	}
+	for name, values := range params {
+		if strings.HasPrefix(name, "act.") && len(values) > 0 {
+			query = query.Filter(elastic.NewMatchQuery(name, values[0]))
+		}
+	}
+	ascOrder := false
+	switch params.Get("sort") {
+	case "", OrderDesc, "-1":
+	case OrderAsc, "1":
+		ascOrder = true
+	default:
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("sort must be one of: asc, desc"))
+	}
+
+	result := new(V2GetActionsResult)
+	result.Total.Relation = "eq"
+	result.Actions = make([]V2Action, 0)
+	searchResult, err := searchActionTraces(client, indices[ActionTracesIndexPrefix], query, skip, limit, ascOrder)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusInternalServerError, err)
+	}
+	if searchResult == nil || searchResult.Hits == nil {
+		return result, nil
+	}
+	result.Total.Value = searchResult.Hits.TotalHits
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		var actionTrace ActionTrace
+		err = json.Unmarshal(*hit.Source, &actionTrace)
+		if err != nil {
+			return nil, newErrorWithCode(http.StatusInternalServerError, errors.New("Failed to parse ES response"))
+		}
+		result.Actions = append(result.Actions, V2Action {
+			Timestamp: actionTrace.BlockTime,
+			BlockNum: actionTrace.BlockNum,
+			TrxId: actionTrace.TrxId,
+			Act: actionTrace.Act,
+			Receiver: actionTrace.Receipt.Receiver,
+			GlobalSequence: actionTrace.Receipt.GlobalSequence,
+			Receipt: actionTrace.Receipt,
+			AccountRamDeltas: actionTrace.AccountRamDeltas })
+	}
+	return result, nil
+}
+
+
+//getV2Transaction returns transaction with flat list of its actions ordered by global sequence
+func getV2Transaction(client *elastic.Client, params url.Values, indices map[string][]string, indicesInfo map[string]IndexInfo) (*V2GetTransactionResult, *ErrorWithCode) {
+	id := params.Get("id")
+	if len(id) == 0 {
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("id is required"))
+	}
+	transaction, error := getTransaction(client, GetTransactionParams { Id: id }, indices, indicesInfo)
+	if error != nil {
+		return nil, error
+	}
+	var traces []TransactionTraceActionTrace
+	err := json.Unmarshal(transaction.Traces, &traces)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusInternalServerError, err)
+	}
+	var receipt struct {
+		Status string `json:"status"`
+	}
+	json.Unmarshal(transaction.Trx["receipt"], &receipt)
+
+	result := new(V2GetTransactionResult)
+	result.TrxId = id
+	result.Executed = receipt.Status == "executed"
+	result.BlockNum = transaction.BlockNum
+	result.BlockTime = transaction.BlockTime
+	result.Actions = flattenActionTraces(traces)
+	return result, nil
+}
+
+//flattenActionTraces returns action traces with all inline traces
+//in one list ordered by global sequence
+func flattenActionTraces(traces []TransactionTraceActionTrace) []TransactionTraceActionTrace {
+	result := make([]TransactionTraceActionTrace, 0, len(traces))
+	queue := traces
+	for len(queue) > 0 {
+		trace := queue[0]
+		queue = append(queue[1:len(queue):len(queue)], trace.InlineTraces...)
+		trace.InlineTraces = make([]TransactionTraceActionTrace, 0)
+		result = append(result, trace)
+	}
+	sequences := make([]uint64, len(result))
+	for i, trace := range result {
+		var receipt struct {
+			GlobalSequence json.RawMessage `json:"global_sequence"`
+		}
+		if json.Unmarshal(trace.Receipt, &receipt) == nil {
+			sequences[i], _ = parseUint64(receipt.GlobalSequence)
+		}
+	}
+	sort.Stable(byGlobalSequence{ traces: result, sequences: sequences })
+	return result
+}
+
+type byGlobalSequence struct {
+	traces    []TransactionTraceActionTrace
+	sequences []uint64
+}
+
+func (b byGlobalSequence) Len() int { return len(b.traces) }
+func (b byGlobalSequence) Less(i, j int) bool { return b.sequences[i] < b.sequences[j] }
+func (b byGlobalSequence) Swap(i, j int) {
+	b.traces[i], b.traces[j] = b.traces[j], b.traces[i]
+	b.sequences[i], b.sequences[j] = b.sequences[j], b.sequences[i]
+}
+
+
+//getV2CreatedAccounts returns accounts created by given account from eosio::newaccount actions
+func getV2CreatedAccounts(client *elastic.Client, params url.Values, indices map[string][]string) (*V2GetCreatedAccountsResult, *ErrorWithCode) {
+	account := params.Get("account")
+	if len(account) == 0 {
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("account is required"))
+	}
+	skip, limit, err := queryPage(params)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusBadRequest, err)
+	}
+	query := newSystemActionQuery("newaccount").
+		Filter(elastic.NewMatchQuery("act.data.creator", account))
+	searchResult, err := searchActionTraces(client, indices[ActionTracesIndexPrefix], query, skip, limit, true)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusInternalServerError, err)
+	}
+
+	result := new(V2GetCreatedAccountsResult)
+	result.Total.Relation = "eq"
+	result.Accounts = make([]V2CreatedAccount, 0)
+	if searchResult == nil || searchResult.Hits == nil {
+		return result, nil
+	}
+	result.Total.Value = searchResult.Hits.TotalHits
+	for _, hit := range searchResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
+			continue
+		}
+		var actionTrace ActionTrace
+		var data NewAccountData
+		err = json.Unmarshal(*hit.Source, &actionTrace)
+		if err == nil {
+			err = json.Unmarshal(actionTrace.Act.Data, &data)
+		}
+		if err != nil {
+			return nil, newErrorWithCode(http.StatusInternalServerError, errors.New("Failed to parse ES response"))
+		}
+		result.Accounts = append(result.Accounts, V2CreatedAccount {
+			Name: data.Name,
+			TrxId: actionTrace.TrxId,
+			Timestamp: actionTrace.BlockTime,
+			BlockNum: actionTrace.BlockNum })
+	}
+	return result, nil
+}
+
+
+//getV2Creator returns creator of account from eosio::newaccount action
+//if the action is not indexed creator is taken from accounts index
+func getV2Creator(client *elastic.Client, params url.Values, indices map[string][]string) (*V2GetCreatorResult, *ErrorWithCode) {
+	account := params.Get("account")
+	if len(account) == 0 {
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("account is required"))
+	}
+	creation, err := getAccountCreation(client, account, indices)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusInternalServerError, err)
+	}
+	if creation == nil {
+		return nil, newErrorWithCode(http.StatusNotFound, errors.New("Account not found"))
+	}
+	result := new(V2GetCreatorResult)
+	result.Account = account
+	result.Creator = creation.Creator
+	result.Timestamp = creation.Timestamp
+	result.BlockNum = creation.BlockNum
+	result.TrxId = creation.TrxId
+	return result, nil
+}
+
+
+//getV2AbiSnapshot returns abi of contract which was active at given block
+//abi versions are reconstructed from eosio::setabi actions by AbiHistory
+func getV2AbiSnapshot(history *AbiHistory, params url.Values) (*V2GetAbiSnapshotResult, *ErrorWithCode) {
+	contract := params.Get("contract")
+	if len(contract) == 0 {
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("contract is required"))
+	}
+	blockNum := uint64(math.MaxUint64)
+	if block := params.Get("block"); len(block) > 0 {
+		var err error
+		blockNum, err = strconv.ParseUint(block, 10, 64)
+		if err != nil {
+			return nil, newErrorWithCode(http.StatusBadRequest, errors.New("Invalid block"))
+		}
+	}
+	version, err := history.abiAt(contract, blockNum, 0)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusInternalServerError, err)
+	}
+	result := new(V2GetAbiSnapshotResult)
+	if version == nil || version.Abi == nil {
+		return result, nil
+	}
+	result.Present = true
+	result.BlockNum = json.RawMessage(strconv.FormatUint(version.BlockNum, 10))
+	if params.Get("fetch") != "false" {
+		result.Abi, err = json.Marshal(version.Abi)
+		if err != nil {
+			return nil, newErrorWithCode(http.StatusInternalServerError, err)
+		}
+	}
+	return result, nil
+}
+
+
+//getV2Tokens finds token contracts account received transfers from
+//and requests current balances from node chain api
+func getV2Tokens(client *elastic.Client, nodes *NodePool, params url.Values, indices map[string][]string) (*V2GetTokensResult, *ErrorWithCode) {
+	account := params.Get("account")
+	if len(account) == 0 {
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("account is required"))
+	}
+	result := new(V2GetTokensResult)
+	result.Account = account
+	result.Tokens = make([]V2Token, 0)
+	if len(indices[ActionTracesIndexPrefix]) == 0 {
+		return result, nil
+	}
+	query := elastic.NewBoolQuery()
+	query = query.Filter(elastic.NewMatchQuery("receipt.receiver", account))
+	query = query.Filter(elastic.NewMatchQuery("act.name", "transfer"))
+	searchResult, err := client.Search(indices[ActionTracesIndexPrefix]...).
+		Query(query).Size(0).
+		Aggregation("contracts", elastic.NewTermsAggregation().Field("act.account.keyword").Size(V2MaxTokenContracts)).
+		Do(context.Background())
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusInternalServerError, err)
+	}
+	contracts, ok := searchResult.Aggregations.Terms("contracts")
+	if !ok {
+		return result, nil
+	}
+	//balances are requested in parallel by at most V2TokenBalanceWorkers at a time,
+	//tokens keep the order of contracts in aggregation
+	tokens := make([][]V2Token, len(contracts.Buckets))
+	workers := make(chan struct{}, V2TokenBalanceWorkers)
+	var wg sync.WaitGroup
+	for i, bucket := range contracts.Buckets {
+		contract, ok := bucket.Key.(string)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		workers <- struct{}{}
+		go func (i int, contract string) {
+			defer wg.Done()
+			defer func () { <-workers }()
+			balances, err := nodes.getCurrencyBalance(contract, account)
+			if err != nil {
+				//not a token contract or node failure, skip it
+				return
+			}
+			for _, balance := range balances {
+				asset, err := parseAsset(balance)
+				if err != nil {
+					continue
+				}
+				tokens[i] = append(tokens[i], V2Token {
+					Symbol: asset.Symbol,
+					Precision: asset.Precision,
+					Amount: asset.Float(),
+					Contract: contract })
+			}
+		}(i, contract)
+	}
+	wg.Wait()
+	for _, contractTokens := range tokens {
+		result.Tokens = append(result.Tokens, contractTokens...)
+	}
+	return result, nil
+}
+
+
+//getV2Links returns current permission links of account
+//by replaying its eosio::linkauth and eosio::unlinkauth actions
+func getV2Links(client *elastic.Client, params url.Values, indices map[string][]string) (*V2GetLinksResult, *ErrorWithCode) {
+	account := params.Get("account")
+	if len(account) == 0 {
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("account is required"))
+	}
+	query := newSystemActionQuery("linkauth", "unlinkauth").
+		Filter(elastic.NewMatchQuery("act.data.account", account))
+	result := new(V2GetLinksResult)
+	result.Total.Relation = "eq"
+	result.Links = make([]V2Link, 0)
+
+	//all actions are replayed in batches, so links are not lost on long histories
+	links := make(map[string]V2Link)
+	order := make([]string, 0)
+	ordered := make(map[string]bool)
+	var afterSeq uint64
+	for {
+		page, err := searchActionsAfter(client, indices[ActionTracesIndexPrefix], query, afterSeq, V2LinksBatchSize)
+		if err != nil {
+			return nil, newErrorWithCode(http.StatusInternalServerError, err)
+		}
+		afterSeq = page.LastSeq
+		for _, action := range page.Actions {
+			var data LinkAuthData
+			var name string
+			err = json.Unmarshal(action.Trace.Act.Data, &data)
+			if err == nil {
+				err = json.Unmarshal(action.Trace.Act.Name, &name)
+			}
+			if err != nil {
+				return nil, newErrorWithCode(http.StatusInternalServerError, errors.New("Failed to parse ES response"))
+			}
+			key := data.Code + ":" + data.Type
+			if name == "unlinkauth" {
+				delete(links, key)
+				continue
+			}
+			if !ordered[key] {
+				ordered[key] = true
+				order = append(order, key)
+			}
+			links[key] = V2Link {
+				BlockNum: action.Trace.BlockNum,
+				Timestamp: action.Trace.BlockTime,
+				Account: data.Account,
+				Permission: data.Requirement,
+				Code: data.Code,
+				Action: data.Type,
+				TrxId: action.Trace.TrxId }
+		}
+		if page.Hits < V2LinksBatchSize {
+			break
+		}
+	}
+	code, action, permission := params.Get("code"), params.Get("action"), params.Get("permission")
+	for _, key := range order {
+		link, ok := links[key]
+		if !ok || (len(code) > 0 && link.Code != code) || (len(action) > 0 && link.Action != action) ||
+			(len(permission) > 0 && link.Permission != permission) {
+			continue
+		}
+		result.Links = append(result.Links, link)
+	}
+	result.Total.Value = int64(len(result.Links))
+	return result, nil
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultWebhooksFile            string = "webhooks.json"
+const WebhookBatchSize                  int = 100
+const WebhookPollIntervalSeconds      int64 = 5
+const WebhookRequestTimeoutSeconds    int64 = 10
+const WebhookMaxAttempts                int = 5
+const WebhookRetryBaseDelaySeconds    int64 = 1
+const WebhookRetryMaxDelaySeconds     int64 = 60
+const WebhookMaxDeadLetters             int = 10000
+const WebhookCircuitBreakerThreshold    int = 5
+const WebhookCircuitOpenSeconds       int64 = 300
+
+
+//errWebhookStopped is returned when delivery is interrupted because webhook was paused or deleted
+var errWebhookStopped = errors.New("Webhook is paused or deleted")
+
+
+//Webhook is a registered http endpoint which receives actions matching its subscriptions
+//LastSeq is the global sequence of the last processed action
+//FailedDeliveries is the number of deliveries moved to the dead-letter list in a row,
+//delivery is suspended until CircuitOpenUntil after WebhookCircuitBreakerThreshold of them
+type Webhook struct {
+	Id                   string `json:"id"`
+	Url                  string `json:"url"`
+	Secret               string `json:"secret,omitempty"`
+	Subscriptions []ActionFilter `json:"subscriptions"`
+	Paused                 bool `json:"paused"`
+	LastSeq              uint64 `json:"last_seq"`
+	FailedDeliveries        int `json:"failed_deliveries"`
+	CircuitOpenUntil *time.Time `json:"circuit_open_until,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+//WebhookDelivery is one action sent to a webhook
+//failed deliveries are kept in the dead-letter list until they are replayed
+type WebhookDelivery struct {
+	Id            string `json:"id"`
+	WebhookId     string `json:"webhook_id"`
+	Seq           uint64 `json:"global_action_seq"`
+	Attempts         int `json:"attempts"`
+	LastError     string `json:"last_error,omitempty"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+//WebhookPayload is the body of a webhook request
+//Action has the same format as get_actions result item
+type WebhookPayload struct {
+	WebhookId  string `json:"webhook_id"`
+	DeliveryId string `json:"delivery_id"`
+	Action     Action `json:"action"`
+}
+
+//webhookState is the content of webhooks file
+type webhookState struct {
+	Webhooks    []*Webhook         `json:"webhooks"`
+	DeadLetters []*WebhookDelivery `json:"dead_letters"`
+}
+
+//WebhookManager keeps webhooks in a json file and runs one delivery worker per webhook
+//Workers read actions from ES after LastSeq of their webhook, so deliveries survive restarts
+//and are made in order of global sequence
+type WebhookManager struct {
+	Server      *Server
+	File        string
+	Webhooks    map[string]*Webhook
+	DeadLetters []*WebhookDelivery
+	//pending replays per webhook, delivered before new actions
+	Replays     map[string][]*WebhookDelivery
+	Workers     map[string]chan struct{}
+	HttpClient  *http.Client
+	Mutex       sync.Mutex
+}
+
+
+func newWebhookManager(s *Server, file string) (*WebhookManager, error) {
+	if len(file) == 0 {
+		file = DefaultWebhooksFile
+	}
+	m := new(WebhookManager)
+	m.Server = s
+	m.File = file
+	m.Webhooks = make(map[string]*Webhook)
+	m.DeadLetters = make([]*WebhookDelivery, 0)
+	m.Replays = make(map[string][]*WebhookDelivery)
+	m.Workers = make(map[string]chan struct{})
+	m.HttpClient = &http.Client { Timeout: time.Duration(WebhookRequestTimeoutSeconds) * time.Second }
+	err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+//start runs delivery workers of all stored webhooks
+func (m *WebhookManager) start() {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	for id, _ := range m.Webhooks {
+		m.startWorker(id)
+	}
+}
+
+func (m *WebhookManager) load() error {
+	data, err := ioutil.ReadFile(m.File)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state webhookState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return errors.New("Failed to parse " + m.File + ": " + err.Error())
+	}
+	for _, webhook := range state.Webhooks {
+		m.Webhooks[webhook.Id] = webhook
+	}
+	if state.DeadLetters != nil {
+		m.DeadLetters = state.DeadLetters
+	}
+	return nil
+}
+
+//save writes current state to the file, must be called with locked mutex
+//the file is replaced atomically so that it is never left half written
+func (m *WebhookManager) save() {
+	state := webhookState { Webhooks: make([]*Webhook, 0, len(m.Webhooks)), DeadLetters: m.DeadLetters }
+	for _, webhook := range m.Webhooks {
+		state.Webhooks = append(state.Webhooks, webhook)
+	}
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		log.Printf("Failed to encode webhooks: %v\n", err)
+		return
+	}
+	tmp := m.File + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, m.File)
+	}
+	if err != nil {
+		log.Printf("Failed to save webhooks: %v\n", err)
+	}
+}
+
+
+func newRandomId(size int) string {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+//signWebhookPayload returns hex encoded HMAC-SHA256 of body
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//webhookRetryDelay returns delay before the next attempt, it doubles after every failed attempt
+func webhookRetryDelay(attempt int) time.Duration {
+	delay := WebhookRetryBaseDelaySeconds
+	for i := 1; i < attempt && delay < WebhookRetryMaxDelaySeconds; i++ {
+		delay *= 2
+	}
+	if delay > WebhookRetryMaxDelaySeconds {
+		delay = WebhookRetryMaxDelaySeconds
+	}
+	return time.Duration(delay) * time.Second
+}
+
+
+//create registers new webhook
+//if startFrom is nil, only actions after the current end of history are delivered
+func (m *WebhookManager) create(url string, secret string, subscriptions []ActionFilter, startFrom *uint64) (*Webhook, error) {
+	if len(url) == 0 {
+		return nil, errors.New("url is required")
+	}
+	err := validateSubscriptions(subscriptions)
+	if err != nil {
+		return nil, err
+	}
+	webhook := new(Webhook)
+	webhook.Id = newRandomId(8)
+	webhook.Url = url
+	webhook.Secret = secret
+	if len(webhook.Secret) == 0 {
+		webhook.Secret = newRandomId(32)
+	}
+	webhook.Subscriptions = subscriptions
+	webhook.CreatedAt = time.Now().UTC()
+	if startFrom != nil {
+		webhook.LastSeq = *startFrom
+	} else {
+		var ready bool
+		webhook.LastSeq, ready = m.Server.Tailer.position()
+		if !ready {
+			return nil, errors.New("Actions tailer is not ready yet, try again later")
+		}
+	}
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	m.Webhooks[webhook.Id] = webhook
+	m.save()
+	m.startWorker(webhook.Id)
+	tmp := *webhook
+	return &tmp, nil
+}
+
+//list returns copies of all webhooks, secrets are returned only by create
+func (m *WebhookManager) list() []Webhook {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	result := make([]Webhook, 0, len(m.Webhooks))
+	for _, webhook := range m.Webhooks {
+		tmp := *webhook
+		tmp.Secret = ""
+		result = append(result, tmp)
+	}
+	return result
+}
+
+func (m *WebhookManager) setPaused(id string, paused bool) error {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	webhook, ok := m.Webhooks[id]
+	if !ok {
+		return errors.New("Webhook not found")
+	}
+	webhook.Paused = paused
+	if !paused {
+		//resumed webhook is tried again immediately
+		webhook.FailedDeliveries = 0
+		webhook.CircuitOpenUntil = nil
+	}
+	m.save()
+	return nil
+}
+
+//remove deletes webhook with its dead-letter deliveries and stops its worker
+func (m *WebhookManager) remove(id string) error {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	if _, ok := m.Webhooks[id]; !ok {
+		return errors.New("Webhook not found")
+	}
+	delete(m.Webhooks, id)
+	delete(m.Replays, id)
+	deadLetters := make([]*WebhookDelivery, 0, len(m.DeadLetters))
+	for _, delivery := range m.DeadLetters {
+		if delivery.WebhookId != id {
+			deadLetters = append(deadLetters, delivery)
+		}
+	}
+	m.DeadLetters = deadLetters
+	if stop, ok := m.Workers[id]; ok {
+		close(stop)
+		delete(m.Workers, id)
+	}
+	m.save()
+	return nil
+}
+
+//deadLetters returns failed deliveries, all of them if webhookId is empty
+func (m *WebhookManager) deadLetters(webhookId string) []WebhookDelivery {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	result := make([]WebhookDelivery, 0)
+	for _, delivery := range m.DeadLetters {
+		if len(webhookId) == 0 || delivery.WebhookId == webhookId {
+			result = append(result, *delivery)
+		}
+	}
+	return result
+}
+
+//replay moves dead-letter deliveries with given ids back to delivery queue
+//returns number of queued deliveries
+func (m *WebhookManager) replay(ids []string) int {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	selected := make(map[string]bool)
+	for _, id := range ids {
+		selected[id] = true
+	}
+	deadLetters := make([]*WebhookDelivery, 0, len(m.DeadLetters))
+	queued := 0
+	for _, delivery := range m.DeadLetters {
+		if selected[delivery.Id] {
+			if _, ok := m.Webhooks[delivery.WebhookId]; ok {
+				delivery.Attempts = 0
+				m.Replays[delivery.WebhookId] = append(m.Replays[delivery.WebhookId], delivery)
+				queued++
+			}
+			continue
+		}
+		deadLetters = append(deadLetters, delivery)
+	}
+	m.DeadLetters = deadLetters
+	m.save()
+	return queued
+}
+
+//rewind makes webhook deliver again all matching actions after startFrom
+func (m *WebhookManager) rewind(id string, startFrom uint64) error {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	webhook, ok := m.Webhooks[id]
+	if !ok {
+		return errors.New("Webhook not found")
+	}
+	webhook.LastSeq = startFrom
+	m.save()
+	return nil
+}
+
+
+//startWorker must be called with locked mutex
+func (m *WebhookManager) startWorker(id string) {
+	if _, ok := m.Workers[id]; ok {
+		return
+	}
+	stop := make(chan struct{})
+	m.Workers[id] = stop
+	go m.runWorker(id, stop)
+}
+
+//snapshot returns a copy of webhook, false if it was deleted
+func (m *WebhookManager) snapshot(id string) (Webhook, bool) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	webhook, ok := m.Webhooks[id]
+	if !ok {
+		return Webhook{}, false
+	}
+	return *webhook, true
+}
+
+func (m *WebhookManager) runWorker(id string, stop chan struct{}) {
+	sub := m.Server.Tailer.subscribe(1)
+	defer func () {
+		m.Server.Tailer.unsubscribe(sub)
+	}()
+	poll := time.NewTicker(time.Duration(WebhookPollIntervalSeconds) * time.Second)
+	defer poll.Stop()
+	for {
+		webhook, ok := m.snapshot(id)
+		if !ok {
+			return
+		}
+		if !webhook.Paused {
+			err := m.deliverPending(id, stop)
+			if err != nil && err != errWebhookStopped {
+				log.Printf("Webhook %s: %v\n", id, err)
+			}
+		}
+		//wait for new actions
+		select {
+		case <-stop:
+			return
+		case <-sub.Actions:
+		case <-sub.Overflow:
+			m.Server.Tailer.unsubscribe(sub)
+			sub = m.Server.Tailer.subscribe(1)
+		case <-poll.C:
+		}
+	}
+}
+
+//deliverPending delivers replayed deliveries and then all new matching actions
+func (m *WebhookManager) deliverPending(id string, stop chan struct{}) error {
+	for {
+		m.Mutex.Lock()
+		replays := m.Replays[id]
+		delete(m.Replays, id)
+		m.Mutex.Unlock()
+		if len(replays) == 0 {
+			break
+		}
+		for i, delivery := range replays {
+			page, err := searchActionsAfter(m.Server.ElasticClient, m.Server.getIndices()[ActionTracesIndexPrefix],
+				elastic.NewBoolQuery(), delivery.Seq - 1, 1)
+			if err == nil && (len(page.Actions) == 0 || page.Actions[0].Seq != delivery.Seq) {
+				err = errors.New("Action " + strconv.FormatUint(delivery.Seq, 10) + " not found")
+			}
+			if err == nil {
+				err = m.deliver(id, delivery, page.Actions[0], stop)
+			}
+			if err != nil {
+				//keep the rest of replays queued
+				m.Mutex.Lock()
+				m.Replays[id] = append(replays[i:], m.Replays[id]...)
+				m.Mutex.Unlock()
+				return err
+			}
+		}
+	}
+
+	for {
+		webhook, ok := m.snapshot(id)
+		if !ok || webhook.Paused {
+			return nil
+		}
+		page, err := searchActionsAfter(m.Server.ElasticClient, m.Server.getIndices()[ActionTracesIndexPrefix],
+			newFiltersQuery(webhook.Subscriptions), webhook.LastSeq, WebhookBatchSize)
+		if err != nil {
+			return err
+		}
+		cursor := webhook.LastSeq
+		rewound := false
+		for _, action := range page.Actions {
+			delivery := &WebhookDelivery { Id: newRandomId(8), WebhookId: id, Seq: action.Seq }
+			err = m.deliver(id, delivery, action, stop)
+			if err != nil {
+				return err
+			}
+			m.Mutex.Lock()
+			webhook, ok := m.Webhooks[id]
+			//cursor is changed by rewind, start over from the new position
+			rewound = !ok || webhook.LastSeq != cursor
+			if !rewound {
+				webhook.LastSeq = action.Seq
+				cursor = action.Seq
+			}
+			m.Mutex.Unlock()
+			if rewound {
+				break
+			}
+		}
+		if page.Hits > 0 {
+			m.Mutex.Lock()
+			//skip hits which can't be parsed
+			if webhook, ok := m.Webhooks[id]; ok && !rewound && webhook.LastSeq == cursor {
+				webhook.LastSeq = page.LastSeq
+			}
+			m.save()
+			m.Mutex.Unlock()
+		}
+		if page.Hits < WebhookBatchSize && !rewound {
+			return nil
+		}
+	}
+}
+
+//deliver sends action to webhook retrying with exponential backoff
+//after WebhookMaxAttempts failed attempts delivery is added to the dead-letter list
+//while circuit of webhook is open, delivery waits for it and probes webhook with a single attempt,
+//failed probe opens the circuit again and the action is not dead-lettered
+//returns error if action can't be prepared or delivery was interrupted
+func (m *WebhookManager) deliver(id string, delivery *WebhookDelivery, action TailedAction, stop chan struct{}) error {
+	actions, err := createTailedActions(m.Server.ElasticClient, []TailedAction{ action },
+		m.Server.getIndices(), m.Server.getIndicesInfo())
+	if err != nil {
+		return err
+	}
+	for {
+		webhook, ok := m.snapshot(id)
+		if !ok || webhook.Paused {
+			return errWebhookStopped
+		}
+		if webhook.CircuitOpenUntil != nil {
+			if wait := time.Until(*webhook.CircuitOpenUntil); wait > 0 {
+				select {
+				case <-stop:
+					return errWebhookStopped
+				case <-time.After(wait):
+				}
+				continue
+			}
+		}
+		delivery.Attempts++
+		err = m.post(webhook, WebhookPayload { WebhookId: id, DeliveryId: delivery.Id, Action: actions[0] })
+		if err == nil {
+			m.recordDelivery(id, false)
+			return nil
+		}
+		if webhook.CircuitOpenUntil != nil {
+			m.recordDelivery(id, true)
+			continue
+		}
+		if delivery.Attempts >= WebhookMaxAttempts {
+			delivery.LastError = err.Error()
+			delivery.FailedAt = time.Now().UTC()
+			m.Mutex.Lock()
+			m.DeadLetters = append(m.DeadLetters, delivery)
+			if len(m.DeadLetters) > WebhookMaxDeadLetters {
+				m.DeadLetters = m.DeadLetters[len(m.DeadLetters) - WebhookMaxDeadLetters:]
+			}
+			m.Mutex.Unlock()
+			log.Printf("Webhook %s: delivery %s failed: %v\n", id, delivery.Id, err)
+			m.recordDelivery(id, true)
+			return nil
+		}
+		select {
+		case <-stop:
+			return errWebhookStopped
+		case <-time.After(webhookRetryDelay(delivery.Attempts)):
+		}
+	}
+}
+
+//recordDelivery updates circuit breaker of webhook after successful or failed delivery
+//circuit opens for WebhookCircuitOpenSeconds after WebhookCircuitBreakerThreshold failed deliveries in a row
+//and closes after the first successful one
+func (m *WebhookManager) recordDelivery(id string, failed bool) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	webhook, ok := m.Webhooks[id]
+	if !ok {
+		return
+	}
+	if !failed {
+		if webhook.FailedDeliveries == 0 && webhook.CircuitOpenUntil == nil {
+			return
+		}
+		webhook.FailedDeliveries = 0
+		webhook.CircuitOpenUntil = nil
+		m.save()
+		return
+	}
+	if webhook.FailedDeliveries < WebhookCircuitBreakerThreshold {
+		webhook.FailedDeliveries++
+	}
+	if webhook.FailedDeliveries >= WebhookCircuitBreakerThreshold {
+		openUntil := time.Now().UTC().Add(time.Duration(WebhookCircuitOpenSeconds) * time.Second)
+		webhook.CircuitOpenUntil = &openUntil
+		log.Printf("Webhook %s: circuit is open until %v\n", id, openUntil)
+	}
+	m.save()
+}
+
+//post sends signed payload to webhook url
+//signature is sent in X-Webhook-Signature header as "sha256=<hex encoded HMAC-SHA256 of body>"
+func (m *WebhookManager) post(webhook Webhook, payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", webhook.Id)
+	req.Header.Set("X-Webhook-Delivery", payload.DeliveryId)
+	req.Header.Set("X-Webhook-Signature", "sha256=" + signWebhookPayload(webhook.Secret, body))
+	resp, err := m.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+
+const AdminApiPath string = "/v1/admin/"
+
+
+//admin api types
+type CreateWebhookParams struct {
+	Url                  string `json:"url"`
+	Secret               string `json:"secret"`
+	Subscriptions []ActionFilter `json:"subscriptions"`
+	StartFrom           *uint64 `json:"start_from,omitempty"`
+}
+
+type WebhookIdParams struct {
+	Id string `json:"id"`
+}
+
+type ListWebhooksResult struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type ListDeliveriesParams struct {
+	WebhookId string `json:"webhook_id"`
+}
+
+type ListDeliveriesResult struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+//ReplayDeliveriesParams either contains ids of dead-letter deliveries to send again
+//or webhook id and global sequence after which all matching actions are sent again
+type ReplayDeliveriesParams struct {
+	DeliveryIds []string `json:"delivery_ids"`
+	WebhookId     string `json:"webhook_id"`
+	StartFrom    *uint64 `json:"start_from,omitempty"`
+}
+
+type ReplayDeliveriesResult struct {
+	Queued int `json:"queued"`
+}
+
+type SuccessResult struct {
+	Success bool `json:"success"`
+}
+
+
+//setAdminRoutes registers webhook admin api
+//the api is served only if admin token is configured
+func (s *Server) setAdminRoutes(token string) {
+	if len(token) == 0 || s.Webhooks == nil {
+		return
+	}
+	http.HandleFunc(AdminApiPath + "create_webhook", s.handleAdmin(token, s.adminCreateWebhook))
+	http.HandleFunc(AdminApiPath + "list_webhooks", s.handleAdmin(token, s.adminListWebhooks))
+	http.HandleFunc(AdminApiPath + "pause_webhook", s.handleAdmin(token, s.adminPauseWebhook))
+	http.HandleFunc(AdminApiPath + "resume_webhook", s.handleAdmin(token, s.adminResumeWebhook))
+	http.HandleFunc(AdminApiPath + "delete_webhook", s.handleAdmin(token, s.adminDeleteWebhook))
+	http.HandleFunc(AdminApiPath + "list_deliveries", s.handleAdmin(token, s.adminListDeliveries))
+	http.HandleFunc(AdminApiPath + "replay_deliveries", s.handleAdmin(token, s.adminReplayDeliveries))
+}
+
+
+//handleAdmin returns http handler that checks "Authorization: Bearer <token>" header,
+//passes request body to handler and sends its result as json
+func (s *Server) handleAdmin(token string, handler func([]byte) (interface{}, *ErrorWithCode)) http.HandlerFunc {
+	return s.onlyGetOrPost(func(w http.ResponseWriter, r *http.Request) {
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer " + token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			response := ErrorResult { Code: http.StatusUnauthorized, Message: "Invalid admin token." }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		result, error := handler(body)
+		if error != nil {
+			w.WriteHeader(error.Code)
+			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
+
+//parseAdminParams decodes request body into params, empty body leaves params unchanged
+func parseAdminParams(body []byte, params interface{}) *ErrorWithCode {
+	if len(body) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(body, params)
+	if err != nil {
+		return newErrorWithCode(http.StatusBadRequest, errors.New("Failed to parse request body"))
+	}
+	return nil
+}
+
+
+func (s *Server) adminCreateWebhook(body []byte) (interface{}, *ErrorWithCode) {
+	var params CreateWebhookParams
+	if error := parseAdminParams(body, &params); error != nil {
+		return nil, error
+	}
+	webhook, err := s.Webhooks.create(params.Url, params.Secret, params.Subscriptions, params.StartFrom)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusBadRequest, err)
+	}
+	return webhook, nil
+}
+
+func (s *Server) adminListWebhooks(body []byte) (interface{}, *ErrorWithCode) {
+	return ListWebhooksResult { Webhooks: s.Webhooks.list() }, nil
+}
+
+func (s *Server) adminPauseWebhook(body []byte) (interface{}, *ErrorWithCode) {
+	var params WebhookIdParams
+	if error := parseAdminParams(body, &params); error != nil {
+		return nil, error
+	}
+	err := s.Webhooks.setPaused(params.Id, true)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusNotFound, err)
+	}
+	return SuccessResult { Success: true }, nil
+}
+
+func (s *Server) adminResumeWebhook(body []byte) (interface{}, *ErrorWithCode) {
+	var params WebhookIdParams
+	if error := parseAdminParams(body, &params); error != nil {
+		return nil, error
+	}
+	err := s.Webhooks.setPaused(params.Id, false)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusNotFound, err)
+	}
+	return SuccessResult { Success: true }, nil
+}
+
+func (s *Server) adminDeleteWebhook(body []byte) (interface{}, *ErrorWithCode) {
+	var params WebhookIdParams
+	if error := parseAdminParams(body, &params); error != nil {
+		return nil, error
+	}
+	err := s.Webhooks.remove(params.Id)
+	if err != nil {
+		return nil, newErrorWithCode(http.StatusNotFound, err)
+	}
+	return SuccessResult { Success: true }, nil
+}
+
+func (s *Server) adminListDeliveries(body []byte) (interface{}, *ErrorWithCode) {
+	var params ListDeliveriesParams
+	if error := parseAdminParams(body, &params); error != nil {
+		return nil, error
+	}
+	return ListDeliveriesResult { Deliveries: s.Webhooks.deadLetters(params.WebhookId) }, nil
+}
+
+func (s *Server) adminReplayDeliveries(body []byte) (interface{}, *ErrorWithCode) {
+	var params ReplayDeliveriesParams
+	if error := parseAdminParams(body, &params); error != nil {
+		return nil, error
+	}
+	if params.StartFrom != nil {
+		err := s.Webhooks.rewind(params.WebhookId, *params.StartFrom)
+		if err != nil {
+			return nil, newErrorWithCode(http.StatusNotFound, err)
+		}
+		return SuccessResult { Success: true }, nil
+	}
+	if len(params.DeliveryIds) == 0 {
+		return nil, newErrorWithCode(http.StatusBadRequest, errors.New("delivery_ids or webhook_id with start_from is required"))
+	}
+	return ReplayDeliveriesResult { Queued: s.Webhooks.replay(params.DeliveryIds) }, nil
+}